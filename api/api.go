@@ -2,60 +2,187 @@ package api
 
 import (
 	"AutoTickets/tickets"
+	"context"
+	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/tidwall/gjson"
 )
 
-const openTicketsQueryUrl = `https://webservices14.autotask.net/atservicesrest/v1.0/tickets/query?search={"filter":[{"op":"noteq","field":"Status","value":5}]}&pagesize=200`
+// page size used when querying tickets
+const ticketPageSize = 200
+
+// upper bound on pages followed for a single query, guards against a nextPageUrl loop
+const maxPages = 50
+
+// Autotask REST client bound to a single zone
+type Client struct {
+	baseUrl         string
+	integrationCode string
+	secret          string
+	username        string
+	tracker         *ThresholdTracker
+	httpClient      *http.Client
+	breaker         *breaker
+}
+
+// returns a client for the zone at baseUrl (e.g. https://webservices14.autotask.net/atservicesrest)
+func NewClient(baseUrl, apiIntegrationCode, apiSecret, apiUsername string) *Client {
+	return &Client{
+		baseUrl:         strings.TrimRight(baseUrl, "/"),
+		integrationCode: apiIntegrationCode,
+		secret:          apiSecret,
+		username:        apiUsername,
+		httpClient:      &http.Client{Timeout: requestTimeout},
+		breaker:         &breaker{},
+	}
+}
+
+// records every request the client makes in tracker
+func (c *Client) SetThresholdTracker(tracker *ThresholdTracker) {
+	c.tracker = tracker
+}
+
+// returns consecutive request failures and circuit breaker state
+func (c *Client) Health() Health {
+	return c.breaker.health()
+}
+
+// returns the zone base url the client sends requests to
+func (c *Client) BaseUrl() string {
+	return c.baseUrl
+}
 
 // polls API and returns open tickets
-func GetOpenTickets(apiIntegrationCode, apiSecret, apiUsername string) ([]tickets.AutotaskTicket, error) {
-	req, err := http.NewRequest("GET", openTicketsQueryUrl, nil)
+func (c *Client) GetOpenTickets(ctx context.Context) ([]tickets.AutotaskTicket, error) {
+	items, err := c.query(ctx, "Tickets", NewQuery(NotEq("status", tickets.StatusComplete)).Max(ticketPageSize))
 	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
+		return nil, err
 	}
-	req.Header.Set("ApiIntegrationCode", apiIntegrationCode)
-	req.Header.Set("Secret", apiSecret)
-	req.Header.Set("UserName", apiUsername)
+	return decodeTickets(items), nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// polls API and returns tickets (open or closed) with activity after since
+func (c *Client) GetTicketsChangedSince(ctx context.Context, since time.Time) ([]tickets.AutotaskTicket, error) {
+	q := NewQuery(Gt("lastActivityDate", since.UTC().Format(time.RFC3339))).Max(ticketPageSize)
+	items, err := c.query(ctx, "Tickets", q)
 	if err != nil {
-		return nil, fmt.Errorf("error making GET request: %w", err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("error response from API: %s", resp.Status)
+		return nil, err
 	}
+	return decodeTickets(items), nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// fetches a single ticket by id
+func (c *Client) GetTicket(ctx context.Context, id int64) (tickets.AutotaskTicket, error) {
+	body, err := c.get(ctx, fmt.Sprintf("%s/v1.0/Tickets/%d", c.baseUrl, id))
 	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
-	}
-
-	var openTickets []tickets.AutotaskTicket
-	jsonStr := string(body)
-	gjson.Get(jsonStr, "items").ForEach(func(_, t gjson.Result) bool {
-		ticket := tickets.AutotaskTicket{
-			ID:                 t.Get("id").Int(),
-			AssignedResourceID: t.Get("assignedResourceID").String(),
-			CreateDate:         t.Get("createDate").String(),
-			Description:        t.Get("description").String(),
-			Title:              t.Get("title").String(),
+		return tickets.AutotaskTicket{}, fmt.Errorf("error fetching ticket %d: %w", id, err)
+	}
+	item := gjson.GetBytes(body, "item")
+	if !item.Exists() || item.Type == gjson.Null {
+		return tickets.AutotaskTicket{}, fmt.Errorf("ticket %d not found", id)
+	}
+	return decodeTickets([]gjson.Result{item})[0], nil
+}
+
+// decodes Tickets entities
+func decodeTickets(items []gjson.Result) []tickets.AutotaskTicket {
+	decoded := make([]tickets.AutotaskTicket, 0, len(items))
+	for _, t := range items {
+		decoded = append(decoded, parseTicket(t))
+	}
+	return decoded
+}
+
+// decodes a single Tickets entity
+func parseTicket(t gjson.Result) tickets.AutotaskTicket {
+	return tickets.AutotaskTicket{
+		ID:                        t.Get("id").Int(),
+		TicketNumber:              t.Get("ticketNumber").String(),
+		AssignedResourceID:        t.Get("assignedResourceID").String(),
+		CreateDate:                parseTime(t.Get("createDate")),
+		Description:               t.Get("description").String(),
+		Title:                     t.Get("title").String(),
+		Priority:                  int(t.Get("priority").Int()),
+		Status:                    int(t.Get("status").Int()),
+		QueueID:                   int(t.Get("queueID").Int()),
+		IssueType:                 int(t.Get("issueType").Int()),
+		Source:                    int(t.Get("source").Int()),
+		CompanyID:                 t.Get("companyID").Int(),
+		ContactID:                 t.Get("contactID").Int(),
+		DueDateTime:               parseTime(t.Get("dueDateTime")),
+		LastActivityDate:          parseTime(t.Get("lastActivityDate")),
+		FirstResponseDueDateTime:  parseTime(t.Get("firstResponseDueDateTime")),
+		ResolutionPlanDueDateTime: parseTime(t.Get("resolutionPlanDueDateTime")),
+		ResolvedDueDateTime:       parseTime(t.Get("resolvedDueDateTime")),
+	}
+}
+
+// parses an Autotask datetime. Autotask returns UTC timestamps, with or without
+// fractional seconds and zone suffix. Null or malformed values return the zero time
+func parseTime(r gjson.Result) time.Time {
+	s := r.String()
+	if s == "" {
+		return time.Time{}
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
 		}
+	}
+	return time.Time{}
+}
 
-		if strings.Contains(strings.ToLower(ticket.Title), "term") {
-			ticket.Title = "Sensitive - view on web"
-			ticket.Description = "Details of this ticket can be found on autotask.net"
+// requests queryUrl and follows pageDetails.nextPageUrl until the last page, returning the items of every page.
+// Fails if any page fails, or if more than maxPages pages are returned
+func (c *Client) getAllPages(ctx context.Context, queryUrl string) ([]gjson.Result, error) {
+	var items []gjson.Result
+	nextUrl := queryUrl
+	for page := 1; nextUrl != ""; page++ {
+		if page > maxPages {
+			return nil, fmt.Errorf("query exceeded max page count (%d)", maxPages)
+		}
+		body, err := c.get(ctx, nextUrl)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching page %d: %w", page, err)
 		}
+		items = append(items, gjson.GetBytes(body, "items").Array()...)
+		nextUrl = gjson.GetBytes(body, "pageDetails.nextPageUrl").String()
+		// the request carries the API credentials, so it is only sent to the client's own zone
+		if nextUrl != "" && !c.sameOrigin(nextUrl) {
+			return nil, fmt.Errorf("page %d links to %q, outside the API zone %s", page, nextUrl, c.baseUrl)
+		}
+	}
+	return items, nil
+}
+
+// true if requestUrl has the scheme and host of the client's base url
+func (c *Client) sameOrigin(requestUrl string) bool {
+	base, err := url.Parse(c.baseUrl)
+	if err != nil {
+		return false
+	}
+	u, err := url.Parse(requestUrl)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Scheme, base.Scheme) && strings.EqualFold(u.Host, base.Host)
+}
 
-		openTickets = append(openTickets, ticket)
-		return true
-	})
+// performs an authenticated GET and returns the response body
+func (c *Client) get(ctx context.Context, requestUrl string) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, requestUrl, nil)
+}
 
-	return openTickets, nil
+// JSON encodes payload and sends it with method (PATCH / POST), returns the response body
+func (c *Client) send(ctx context.Context, method, requestUrl string, payload any) ([]byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding request: %w", err)
+	}
+	return c.do(ctx, method, requestUrl, body)
 }
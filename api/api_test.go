@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// fake Autotask query endpoint serving pageCount pages of pageSize tickets each.
+// failPage (if non-zero) responds 500 for that page number
+func newPagedServer(t *testing.T, pageCount, pageSize, failPage int) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("ApiIntegrationCode") != "code" || r.Header.Get("Secret") != "secret" || r.Header.Get("UserName") != "user" {
+			http.Error(w, "missing credentials", http.StatusUnauthorized)
+			return
+		}
+		page := 1
+		if p := r.URL.Query().Get("page"); p != "" {
+			page, _ = strconv.Atoi(p)
+		}
+		if page == failPage {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+
+		items := make([]string, 0, pageSize)
+		for i := 0; i < pageSize; i++ {
+			id := (page-1)*pageSize + i + 1
+			items = append(items, fmt.Sprintf(`{"id":%d,"title":"ticket %d","assignedResourceID":null}`, id, id))
+		}
+		next := "null"
+		if pageCount <= 0 || page < pageCount {
+			next = strconv.Quote(fmt.Sprintf("%s/v1.0/tickets/query?page=%d", srv.URL, page+1))
+		}
+		fmt.Fprintf(w, `{"items":[%s],"pageDetails":{"count":%d,"nextPageUrl":%s}}`, strings.Join(items, ","), pageSize, next)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGetOpenTicketsFollowsEveryPage(t *testing.T) {
+	srv := newPagedServer(t, 3, 200, 0)
+
+	got, err := NewClient(srv.URL, "code", "secret", "user").GetOpenTickets(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 600 {
+		t.Fatalf("expected 600 tickets, got %d", len(got))
+	}
+	for i, ticket := range got {
+		if ticket.ID != int64(i+1) {
+			t.Fatalf("ticket %d: expected id %d, got %d", i, i+1, ticket.ID)
+		}
+	}
+}
+
+func TestGetOpenTicketsSinglePage(t *testing.T) {
+	srv := newPagedServer(t, 1, 5, 0)
+
+	got, err := NewClient(srv.URL, "code", "secret", "user").GetOpenTickets(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("expected 5 tickets, got %d", len(got))
+	}
+}
+
+func TestGetOpenTicketsPageFailure(t *testing.T) {
+	srv := newPagedServer(t, 3, 10, 2)
+
+	got, err := NewClient(srv.URL, "code", "secret", "user").GetOpenTickets(context.Background())
+	if err == nil {
+		t.Fatal("expected error when a page fails mid-walk")
+	}
+	if got != nil {
+		t.Fatalf("expected no tickets on failure, got %d", len(got))
+	}
+	if !strings.Contains(err.Error(), "page 2") || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("error should name the failed page and status, got %q", err)
+	}
+}
+
+func TestGetOpenTicketsPageCap(t *testing.T) {
+	// pageCount 0 never ends: every page links to another
+	srv := newPagedServer(t, 0, 1, 0)
+
+	_, err := NewClient(srv.URL, "code", "secret", "user").GetOpenTickets(context.Background())
+	if err == nil {
+		t.Fatal("expected error when page cap is exceeded")
+	}
+	if !strings.Contains(err.Error(), "max page count") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestGetOpenTicketsRejectsForeignNextPage(t *testing.T) {
+	var leaked bool
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("Secret") != ""
+		fmt.Fprint(w, `{"items":[],"pageDetails":{"nextPageUrl":null}}`)
+	}))
+	t.Cleanup(foreign.Close)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"items":[{"id":1}],"pageDetails":{"nextPageUrl":%q}}`, foreign.URL+"/v1.0/tickets/query?page=2")
+	}))
+	t.Cleanup(srv.Close)
+
+	_, err := NewClient(srv.URL, "code", "secret", "user").GetOpenTickets(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "outside the API zone") {
+		t.Fatalf("expected foreign next page to be refused, got %v", err)
+	}
+	if leaked {
+		t.Fatal("credentials were sent to the foreign host")
+	}
+}
+
+func TestResolveZone(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("user") != "api@example.com" {
+			http.Error(w, "unknown user", http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"zoneName":"America West 3","url":"https://webservices14.autotask.net/atservicesrest/","ci":0}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	got, err := resolveZone(context.Background(), srv.URL, "api@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "https://webservices14.autotask.net/atservicesrest/" {
+		t.Fatalf("unexpected zone url %q", got)
+	}
+	if NewClient(got, "", "", "").BaseUrl() != "https://webservices14.autotask.net/atservicesrest" {
+		t.Fatal("client should trim trailing slash from zone url")
+	}
+
+	if _, err := resolveZone(context.Background(), srv.URL, "nobody@example.com"); err == nil {
+		t.Fatal("expected error for unknown user")
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want time.Time
+	}{
+		{"zone suffix", `{"d":"2024-03-05T14:07:09Z"}`, time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC)},
+		{"fraction and zone suffix", `{"d":"2024-03-05T14:07:09.123Z"}`, time.Date(2024, 3, 5, 14, 7, 9, 123000000, time.UTC)},
+		{"offset", `{"d":"2024-03-05T16:07:09+02:00"}`, time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC)},
+		{"no zone", `{"d":"2024-03-05T14:07:09"}`, time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC)},
+		{"fraction without zone", `{"d":"2024-03-05T14:07:09.4567"}`, time.Date(2024, 3, 5, 14, 7, 9, 456700000, time.UTC)},
+		{"null", `{"d":null}`, time.Time{}},
+		{"missing", `{}`, time.Time{}},
+		{"empty", `{"d":""}`, time.Time{}},
+		{"date only", `{"d":"2024-03-05"}`, time.Time{}},
+		{"out of range", `{"d":"2024-13-45T14:07:09Z"}`, time.Time{}},
+		{"not a date", `{"d":"yesterday"}`, time.Time{}},
+	}
+	for _, tt := range tests {
+		got := parseTime(gjson.Get(tt.json, "d"))
+		if !got.Equal(tt.want) || got.Location() != time.UTC {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestParseTicket(t *testing.T) {
+	tests := []struct {
+		name       string
+		json       string
+		resourceID string
+		created    time.Time
+		due        time.Time
+	}{
+		{"unassigned", `{"id":7,"assignedResourceID":null,"createDate":"2024-03-05T14:07:09.12Z","dueDateTime":null}`,
+			"", time.Date(2024, 3, 5, 14, 7, 9, 120000000, time.UTC), time.Time{}},
+		{"assigned", `{"id":7,"assignedResourceID":29682885,"createDate":"2024-03-05T14:07:09","dueDateTime":"2024-03-06T09:00:00Z"}`,
+			"29682885", time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC), time.Date(2024, 3, 6, 9, 0, 0, 0, time.UTC)},
+		{"fields missing", `{"id":7}`, "", time.Time{}, time.Time{}},
+		{"malformed dates", `{"id":7,"createDate":"soon","dueDateTime":42}`, "", time.Time{}, time.Time{}},
+	}
+	for _, tt := range tests {
+		ticket := parseTicket(gjson.Parse(tt.json))
+		if ticket.ID != 7 || ticket.AssignedResourceID != tt.resourceID {
+			t.Errorf("%s: expected ticket 7 assigned to %q, got %d %q", tt.name, tt.resourceID, ticket.ID, ticket.AssignedResourceID)
+		}
+		if !ticket.CreateDate.Equal(tt.created) || !ticket.DueDateTime.Equal(tt.due) {
+			t.Errorf("%s: expected created %v due %v, got %v %v", tt.name, tt.created, tt.due, ticket.CreateDate, ticket.DueDateTime)
+		}
+	}
+
+	full := parseTicket(gjson.Parse(`{"id":42,"ticketNumber":"T20240305.0001","title":"Printer offline","description":"3rd floor",` +
+		`"priority":2,"status":1,"queueID":29683412,"issueType":7,"source":2,"companyID":174,"contactID":30683099,` +
+		`"lastActivityDate":"2024-03-05T15:00:00Z","firstResponseDueDateTime":"2024-03-05T16:00:00Z",` +
+		`"resolutionPlanDueDateTime":"2024-03-05T17:00:00Z","resolvedDueDateTime":"2024-03-05T18:00:00Z"}`))
+	if full.TicketNumber != "T20240305.0001" || full.Title != "Printer offline" || full.Description != "3rd floor" ||
+		full.Priority != 2 || full.Status != 1 || full.QueueID != 29683412 || full.IssueType != 7 || full.Source != 2 ||
+		full.CompanyID != 174 || full.ContactID != 30683099 {
+		t.Errorf("unexpected fields %+v", full)
+	}
+	if full.LastActivityDate.Hour() != 15 || full.FirstResponseDueDateTime.Hour() != 16 ||
+		full.ResolutionPlanDueDateTime.Hour() != 17 || full.ResolvedDueDateTime.Hour() != 18 {
+		t.Errorf("unexpected dates %+v", full)
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// time an id the API did not return is remembered as missing, so an unknown id is requested
+// at most this often, and a new or restored entity is picked up soon after
+const missTtl = 2 * time.Minute
+
+// thread-safe entity cache keyed by id, entries expire after ttl (missing ids after missTtl).
+// Expired entries are swept out as new ones are set, at most once per missTtl
+type ttlCache[T any] struct {
+	sync.RWMutex
+	ttl       time.Duration
+	missTtl   time.Duration
+	entries   map[int64]cacheEntry[T]
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+// cached value and its expiry
+type cacheEntry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// returns an empty cache whose entries expire after ttl
+func newTtlCache[T any](ttl time.Duration) *ttlCache[T] {
+	return &ttlCache[T]{ttl: ttl, missTtl: min(ttl, missTtl), entries: make(map[int64]cacheEntry[T]), now: time.Now}
+}
+
+// returns unexpired cached values for ids, and the ids that must be (re)loaded
+func (tc *ttlCache[T]) lookup(ids []int64) (map[int64]T, []int64) {
+	tc.RLock()
+	defer tc.RUnlock()
+	now := tc.now()
+	found := make(map[int64]T, len(ids))
+	var missing []int64
+	for _, id := range ids {
+		if _, seen := found[id]; seen {
+			continue
+		}
+		if e, ok := tc.entries[id]; ok && now.Before(e.expires) {
+			found[id] = e.value
+		} else if !slices.Contains(missing, id) {
+			missing = append(missing, id)
+		}
+	}
+	return found, missing
+}
+
+// caches value under id for ttl, first dropping expired entries if a sweep is due,
+// so ids that are never requested again don't stay in memory
+func (tc *ttlCache[T]) set(id int64, value T, ttl time.Duration) {
+	tc.Lock()
+	defer tc.Unlock()
+	now := tc.now()
+	if !now.Before(tc.nextSweep) {
+		for i, e := range tc.entries {
+			if !now.Before(e.expires) {
+				delete(tc.entries, i)
+			}
+		}
+		tc.nextSweep = now.Add(tc.missTtl)
+	}
+	tc.entries[id] = cacheEntry[T]{value: value, expires: now.Add(ttl)}
+}
+
+// loads ids missing from the cache through load and caches the results. Ids that load
+// does not return are cached as zero values for missTtl, so unknown ids are not requested every poll
+func (tc *ttlCache[T]) resolve(ids []int64, load func([]int64) (map[int64]T, error)) (map[int64]T, error) {
+	found, missing := tc.lookup(ids)
+	if len(missing) == 0 {
+		return found, nil
+	}
+	loaded, err := load(missing)
+	if err != nil {
+		return found, err
+	}
+	for _, id := range missing {
+		value, ok := loaded[id]
+		if ok {
+			tc.set(id, value, tc.ttl)
+		} else {
+			tc.set(id, value, tc.missTtl)
+		}
+		found[id] = value
+	}
+	return found, nil
+}
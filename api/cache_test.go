@@ -0,0 +1,242 @@
+package api
+
+import (
+	"AutoTickets/tickets"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// fake Autotask query endpoint serving entities, keyed by entity name then id, as JSON objects without
+// their id. Answers "in id" queries with the entities it has, and counts the ids requested per entity
+type queryServer struct {
+	*httptest.Server
+	sync.Mutex
+	entities  map[string]map[int64]string
+	requested map[string][]int64
+}
+
+func newQueryServer(t *testing.T, entities map[string]map[int64]string) *queryServer {
+	t.Helper()
+	qs := &queryServer{entities: entities, requested: make(map[string][]int64)}
+	qs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entity := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1.0/"), "/query")
+		qs.Lock()
+		defer qs.Unlock()
+		var items []string
+		for _, id := range gjson.Get(r.URL.Query().Get("search"), "filter.0.value").Array() {
+			qs.requested[entity] = append(qs.requested[entity], id.Int())
+			if fields, ok := qs.entities[entity][id.Int()]; ok {
+				items = append(items, fmt.Sprintf(`{"id":%d,%s}`, id.Int(), strings.Trim(fields, "{}")))
+			}
+		}
+		fmt.Fprintf(w, `{"items":[%s],"pageDetails":{"nextPageUrl":null}}`, strings.Join(items, ","))
+	}))
+	t.Cleanup(qs.Close)
+	return qs
+}
+
+// returns and clears the ids requested for entity
+func (qs *queryServer) takeRequested(entity string) []int64 {
+	qs.Lock()
+	defer qs.Unlock()
+	ids := qs.requested[entity]
+	delete(qs.requested, entity)
+	return ids
+}
+
+// a fake clock for ttl caches
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestTtlCacheResolve(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	cache := newTtlCache[string](time.Hour)
+	cache.now = clock.now
+
+	var loads [][]int64
+	load := func(ids []int64) (map[int64]string, error) {
+		loads = append(loads, ids)
+		values := make(map[int64]string)
+		for _, id := range ids {
+			if id != 404 {
+				values[id] = fmt.Sprint("value ", id)
+			}
+		}
+		return values, nil
+	}
+
+	got, err := cache.resolve([]int64{1, 2, 1, 404}, load)
+	if err != nil || got[1] != "value 1" || got[2] != "value 2" || got[404] != "" {
+		t.Fatalf("unexpected values %v %v", got, err)
+	}
+	if fmt.Sprint(loads) != "[[1 2 404]]" {
+		t.Fatalf("expected duplicates to be loaded once, got %v", loads)
+	}
+
+	// cached values and misses aren't loaded again until they expire
+	loads = nil
+	cache.resolve([]int64{1, 2, 404}, load)
+	if loads != nil {
+		t.Errorf("expected cached ids not to be loaded, got %v", loads)
+	}
+	clock.advance(missTtl)
+	cache.resolve([]int64{1, 2, 404}, load)
+	if fmt.Sprint(loads) != "[[404]]" {
+		t.Errorf("expected only the missing id to be loaded again after missTtl, got %v", loads)
+	}
+	clock.advance(time.Hour)
+	loads = nil
+	cache.resolve([]int64{1, 2}, load)
+	if fmt.Sprint(loads) != "[[1 2]]" {
+		t.Errorf("expected expired ids to be loaded again, got %v", loads)
+	}
+}
+
+func TestTtlCacheSweepsExpired(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	cache := newTtlCache[string](time.Hour)
+	cache.now = clock.now
+
+	cache.set(1, "value", cache.missTtl*3/2)
+	cache.set(404, "", cache.missTtl)
+	clock.advance(cache.missTtl)
+	cache.set(2, "value", time.Hour)
+	if _, ok := cache.entries[404]; ok || len(cache.entries) != 2 {
+		t.Errorf("expected the expired miss to be swept, got %v", cache.entries)
+	}
+
+	// entry 1 expires, but the next sweep isn't due until missTtl after the last
+	clock.advance(cache.missTtl / 2)
+	cache.set(3, "value", time.Hour)
+	if _, ok := cache.entries[1]; !ok {
+		t.Error("expected no sweep within missTtl of the last")
+	}
+	clock.advance(cache.missTtl / 2)
+	cache.set(4, "value", time.Hour)
+	if _, ok := cache.entries[1]; ok || len(cache.entries) != 3 {
+		t.Errorf("expected the expired entry to be swept, got %v", cache.entries)
+	}
+}
+
+func TestTtlCacheLoadFailure(t *testing.T) {
+	cache := newTtlCache[string](time.Hour)
+	cache.set(1, "cached", time.Hour)
+	got, err := cache.resolve([]int64{1, 2}, func([]int64) (map[int64]string, error) {
+		return nil, errors.New("boom")
+	})
+	if err == nil || got[1] != "cached" {
+		t.Fatalf("expected cached values and the error, got %v %v", got, err)
+	}
+	// failures aren't cached
+	if _, missing := cache.lookup([]int64{2}); len(missing) != 1 {
+		t.Error("expected the id that failed to load to be requested again")
+	}
+}
+
+func TestResourceCacheApplyNames(t *testing.T) {
+	qs := newQueryServer(t, map[string]map[int64]string{"Resources": {
+		29682885: `{"firstName":"Ada","lastName":"Lovelace","email":"ada@example.com","isActive":true}`,
+		29682886: `{"firstName":"Alan","lastName":"Turing","email":"alan@example.com","isActive":true}`,
+	}})
+	client := NewClient(qs.URL, "", "", "")
+	rc := NewResourceCache(time.Hour)
+	clock := &testClock{t: time.Now()}
+	rc.cache.now = clock.now
+
+	ts := []tickets.AutotaskTicket{
+		{ID: 1, AssignedResourceID: "29682885"},
+		{ID: 2, AssignedResourceID: "29682886"},
+		{ID: 3, AssignedResourceID: "29682885"},
+		{ID: 4, AssignedResourceID: "1"},
+		{ID: 5},
+	}
+	if err := rc.ApplyNames(context.Background(), client, ts); err != nil {
+		t.Fatal(err)
+	}
+	if ts[0].AssignedResourceName != "Ada Lovelace" || ts[0].AssignedResourceEmail != "ada@example.com" ||
+		ts[1].AssignedResourceName != "Alan Turing" || ts[2].AssignedResourceName != "Ada Lovelace" {
+		t.Errorf("unexpected names %+v", ts[:3])
+	}
+	if ts[3].AssignedResourceName != "" || ts[4].AssignedResourceName != "" {
+		t.Errorf("expected unknown and unassigned tickets to stay unnamed, got %+v", ts[3:])
+	}
+	if got := qs.takeRequested("Resources"); fmt.Sprint(got) != "[29682885 29682886 1]" {
+		t.Errorf("expected one batched lookup of each id, got %v", got)
+	}
+
+	// a resource created since is found once the miss expires
+	qs.Lock()
+	qs.entities["Resources"][1] = `{"firstName":"Grace","lastName":"Hopper"}`
+	qs.Unlock()
+	rc.ApplyNames(context.Background(), client, ts)
+	if got := qs.takeRequested("Resources"); got != nil || ts[3].AssignedResourceName != "" {
+		t.Errorf("expected the miss to be cached, requested %v", got)
+	}
+	clock.advance(missTtl)
+	rc.ApplyNames(context.Background(), client, ts)
+	if got := qs.takeRequested("Resources"); fmt.Sprint(got) != "[1]" || ts[3].AssignedResourceName != "Grace Hopper" {
+		t.Errorf("expected the missing resource to be looked up again, requested %v, got %q", got, ts[3].AssignedResourceName)
+	}
+}
+
+func TestResourceCacheActiveByEmail(t *testing.T) {
+	var queries int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		fmt.Fprint(w, `{"items":[{"id":29682885,"firstName":"Ada","email":"Ada@Example.com","isActive":true},`+
+			`{"id":29682886,"email":"shared@example.com","isActive":true},{"id":29682887,"email":"shared@example.com","isActive":true},`+
+			`{"id":29682888,"email":"","isActive":true}],"pageDetails":{"nextPageUrl":null}}`)
+	}))
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "", "", "")
+	rc := NewResourceCache(time.Hour)
+	clock := &testClock{t: time.Now()}
+	rc.cache.now = clock.now
+
+	if _, ok := rc.ActiveByEmail("ada@example.com"); ok {
+		t.Error("expected no match before the index is loaded")
+	}
+	for i := 0; i < 2; i++ {
+		if err := rc.RefreshActive(context.Background(), client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if queries != 1 {
+		t.Errorf("expected the index to load once, queried %d times", queries)
+	}
+	cases := []struct {
+		email string
+		id    int64
+	}{
+		{"ada@example.com", 29682885},
+		{" ADA@example.com ", 29682885},
+		{"shared@example.com", 0},
+		{"", 0},
+		{"eve@example.com", 0},
+	}
+	for _, c := range cases {
+		if id, ok := rc.ActiveByEmail(c.email); id != c.id || ok != (c.id != 0) {
+			t.Errorf("%q: expected %d, got %d %v", c.email, c.id, id, ok)
+		}
+	}
+	if resources, _ := rc.Resolve(context.Background(), client, []int64{29682885}); resources[29682885].Name() != "Ada" || queries != 1 {
+		t.Errorf("expected loaded resources to be cached by id, got %+v after %d queries", resources, queries)
+	}
+
+	clock.advance(activeRefresh)
+	rc.RefreshActive(context.Background(), client)
+	if queries != 2 {
+		t.Errorf("expected a stale index to reload, queried %d times", queries)
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"AutoTickets/tickets"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// returned when claiming a ticket that another resource already owns
+type AlreadyAssignedError struct {
+	TicketID   int64
+	ResourceID string
+}
+
+func (e *AlreadyAssignedError) Error() string {
+	return fmt.Sprintf("ticket %d is already assigned to resource %s", e.TicketID, e.ResourceID)
+}
+
+// fetches active resources, for choosing who claims tickets
+func (c *Client) GetActiveResources(ctx context.Context) ([]Resource, error) {
+	q := NewQuery(Eq("isActive", true)).Include("id", "firstName", "lastName", "email", "isActive").Max(maxRecordsLimit)
+	items, err := c.query(ctx, "Resources", q)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching resources: %w", err)
+	}
+	resources := make([]Resource, 0, len(items))
+	for _, item := range items {
+		resources = append(resources, parseResource(item))
+	}
+	return resources, nil
+}
+
+// returns the role a resource is assigned tickets under: its default active
+// role, or its first active role if none is marked default
+func (c *Client) GetDefaultRoleID(ctx context.Context, resourceID int64) (int64, error) {
+	q := NewQuery(Eq("resourceID", resourceID), Eq("isActive", true)).Include("roleID", "isDefault")
+	items, err := c.query(ctx, "ResourceRoleDepartments", q)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching roles of resource %d: %w", resourceID, err)
+	}
+	if len(items) == 0 {
+		return 0, fmt.Errorf("resource %d has no active role", resourceID)
+	}
+	for _, item := range items {
+		if item.Get("isDefault").Bool() {
+			return item.Get("roleID").Int(), nil
+		}
+	}
+	return items[0].Get("roleID").Int(), nil
+}
+
+// assigns ticketID to resourceID under roleID (0 looks up the resource's default role).
+// The ticket is re-read first: claiming fails with *AlreadyAssignedError if someone else
+// owns it, and succeeds without writing if resourceID already does
+func (c *Client) ClaimTicket(ctx context.Context, ticketID, resourceID, roleID int64) (tickets.AutotaskTicket, error) {
+	current, err := c.GetTicket(ctx, ticketID)
+	if err != nil {
+		return tickets.AutotaskTicket{}, err
+	}
+	if current.Status == tickets.StatusComplete {
+		return current, fmt.Errorf("ticket %d is complete", ticketID)
+	}
+	if current.AssignedResourceID != "" {
+		if current.AssignedResourceID == strconv.FormatInt(resourceID, 10) {
+			return current, nil
+		}
+		return current, &AlreadyAssignedError{TicketID: ticketID, ResourceID: current.AssignedResourceID}
+	}
+
+	if roleID == 0 {
+		if roleID, err = c.GetDefaultRoleID(ctx, resourceID); err != nil {
+			return current, err
+		}
+	}
+	update := map[string]any{
+		"id":                     ticketID,
+		"assignedResourceID":     resourceID,
+		"assignedResourceRoleID": roleID,
+	}
+	if _, err := c.send(ctx, http.MethodPatch, c.baseUrl+"/v1.0/Tickets", update); err != nil {
+		return current, fmt.Errorf("error assigning ticket %d: %w", ticketID, err)
+	}
+	current.AssignedResourceID = strconv.FormatInt(resourceID, 10)
+	return current, nil
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fake Autotask serving one ticket and the roles of its resources, recording the ticket updates it receives
+type claimServer struct {
+	*httptest.Server
+	sync.Mutex
+	ticket     string
+	roles      string
+	roleLookup int
+	updates    []map[string]any
+}
+
+func newClaimServer(t *testing.T, ticket, roles string) *claimServer {
+	t.Helper()
+	cs := &claimServer{ticket: ticket, roles: roles}
+	cs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cs.Lock()
+		defer cs.Unlock()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1.0/Tickets/42":
+			fmt.Fprintf(w, `{"item":%s}`, cs.ticket)
+		case r.Method == http.MethodGet && r.URL.Path == "/v1.0/ResourceRoleDepartments/query":
+			cs.roleLookup++
+			fmt.Fprintf(w, `{"items":%s,"pageDetails":{"nextPageUrl":null}}`, cs.roles)
+		case r.Method == http.MethodPatch && r.URL.Path == "/v1.0/Tickets":
+			body, _ := io.ReadAll(r.Body)
+			var update map[string]any
+			json.Unmarshal(body, &update)
+			cs.updates = append(cs.updates, update)
+			fmt.Fprint(w, `{"itemId":42}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(cs.Close)
+	return cs
+}
+
+func TestClaimTicket(t *testing.T) {
+	const (
+		unassigned  = `{"id":42,"status":1,"assignedResourceID":null}`
+		defaultRole = `[{"roleID":10,"isDefault":false},{"roleID":11,"isDefault":true}]`
+	)
+	tests := []struct {
+		name       string
+		ticket     string
+		roles      string
+		roleID     int64
+		wantErr    func(error) bool
+		wantRole   float64
+		roleLookup int
+	}{
+		{name: "default role", ticket: unassigned, roles: defaultRole, wantRole: 11, roleLookup: 1},
+		{name: "first active role without a default", ticket: unassigned, roles: `[{"roleID":10},{"roleID":12}]`, wantRole: 10, roleLookup: 1},
+		{name: "explicit role", ticket: unassigned, roles: defaultRole, roleID: 13, wantRole: 13},
+		{
+			name:       "no active role",
+			ticket:     unassigned,
+			roles:      `[]`,
+			wantErr:    func(err error) bool { return strings.Contains(err.Error(), "no active role") },
+			roleLookup: 1,
+		},
+		{
+			name:   "claimed by someone else since",
+			ticket: `{"id":42,"status":1,"assignedResourceID":29682886}`,
+			wantErr: func(err error) bool {
+				var assigned *AlreadyAssignedError
+				return errors.As(err, &assigned) && assigned.ResourceID == "29682886" && assigned.TicketID == 42
+			},
+		},
+		{name: "already mine", ticket: `{"id":42,"status":1,"assignedResourceID":29682885}`},
+		{
+			name:    "complete",
+			ticket:  `{"id":42,"status":5,"assignedResourceID":null}`,
+			wantErr: func(err error) bool { return strings.Contains(err.Error(), "complete") },
+		},
+	}
+	for _, tt := range tests {
+		cs := newClaimServer(t, tt.ticket, tt.roles)
+		got, err := NewClient(cs.URL, "", "", "").ClaimTicket(context.Background(), 42, 29682885, tt.roleID)
+		if tt.wantErr != nil {
+			if err == nil || !tt.wantErr(err) {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			if len(cs.updates) != 0 {
+				t.Errorf("%s: expected no update, got %v", tt.name, cs.updates)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			if got.AssignedResourceID != "29682885" {
+				t.Errorf("%s: expected the ticket to be assigned to the claimer, got %q", tt.name, got.AssignedResourceID)
+			}
+			if tt.wantRole == 0 && len(cs.updates) != 0 {
+				t.Errorf("%s: expected no update, got %v", tt.name, cs.updates)
+			}
+			if tt.wantRole != 0 && (len(cs.updates) != 1 || cs.updates[0]["assignedResourceRoleID"] != tt.wantRole ||
+				cs.updates[0]["assignedResourceID"] != float64(29682885) || cs.updates[0]["id"] != float64(42)) {
+				t.Errorf("%s: unexpected updates %v", tt.name, cs.updates)
+			}
+		}
+		if cs.roleLookup != tt.roleLookup {
+			t.Errorf("%s: expected %d role lookups, got %d", tt.name, tt.roleLookup, cs.roleLookup)
+		}
+	}
+}
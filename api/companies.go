@@ -0,0 +1,121 @@
+package api
+
+import (
+	"AutoTickets/tickets"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// default time a resolved company or contact is cached
+const DefaultCompanyTtl = 6 * time.Hour
+
+// Autotask company (customer)
+type Company struct {
+	ID          int64  `json:"id"`
+	CompanyName string `json:"companyName"`
+}
+
+// Autotask contact at a company
+type Contact struct {
+	ID           int64  `json:"id"`
+	CompanyID    int64  `json:"companyID"`
+	FirstName    string `json:"firstName"`
+	LastName     string `json:"lastName"`
+	EmailAddress string `json:"emailAddress"`
+}
+
+// returns the contact's display name
+func (c Contact) Name() string {
+	return strings.TrimSpace(c.FirstName + " " + c.LastName)
+}
+
+// fetches companies by id
+func (c *Client) GetCompanies(ctx context.Context, ids []int64) (map[int64]Company, error) {
+	items, err := c.queryByIds(ctx, "Companies", ids, "id", "companyName")
+	if err != nil {
+		return nil, fmt.Errorf("error fetching companies: %w", err)
+	}
+	companies := make(map[int64]Company, len(items))
+	for _, item := range items {
+		company := Company{
+			ID:          item.Get("id").Int(),
+			CompanyName: item.Get("companyName").String(),
+		}
+		companies[company.ID] = company
+	}
+	return companies, nil
+}
+
+// fetches contacts by id
+func (c *Client) GetContacts(ctx context.Context, ids []int64) (map[int64]Contact, error) {
+	items, err := c.queryByIds(ctx, "Contacts", ids, "id", "companyID", "firstName", "lastName", "emailAddress")
+	if err != nil {
+		return nil, fmt.Errorf("error fetching contacts: %w", err)
+	}
+	contacts := make(map[int64]Contact, len(items))
+	for _, item := range items {
+		contact := parseContact(item)
+		contacts[contact.ID] = contact
+	}
+	return contacts, nil
+}
+
+// decodes a single Contacts entity
+func parseContact(c gjson.Result) Contact {
+	return Contact{
+		ID:           c.Get("id").Int(),
+		CompanyID:    c.Get("companyID").Int(),
+		FirstName:    c.Get("firstName").String(),
+		LastName:     c.Get("lastName").String(),
+		EmailAddress: c.Get("emailAddress").String(),
+	}
+}
+
+// companies and contacts cached by id
+type CompanyCache struct {
+	companies *ttlCache[Company]
+	contacts  *ttlCache[Contact]
+}
+
+// returns an empty company / contact cache whose entries expire after ttl
+func NewCompanyCache(ttl time.Duration) *CompanyCache {
+	return &CompanyCache{
+		companies: newTtlCache[Company](ttl),
+		contacts:  newTtlCache[Contact](ttl),
+	}
+}
+
+// sets company name, contact name and contact email on every ticket in ts.
+// Ids are looked up in one batched query per entity; on failure, cached entries are still applied
+func (cc *CompanyCache) ApplyNames(ctx context.Context, c *Client, ts []tickets.AutotaskTicket) error {
+	var companyIds, contactIds []int64
+	for _, t := range ts {
+		if t.CompanyID != 0 {
+			companyIds = append(companyIds, t.CompanyID)
+		}
+		if t.ContactID != 0 {
+			contactIds = append(contactIds, t.ContactID)
+		}
+	}
+
+	companies, companyErr := cc.companies.resolve(companyIds, func(ids []int64) (map[int64]Company, error) {
+		return c.GetCompanies(ctx, ids)
+	})
+	contacts, contactErr := cc.contacts.resolve(contactIds, func(ids []int64) (map[int64]Contact, error) {
+		return c.GetContacts(ctx, ids)
+	})
+	for i := range ts {
+		ts[i].CompanyName = companies[ts[i].CompanyID].CompanyName
+		ts[i].ContactName = contacts[ts[i].ContactID].Name()
+		ts[i].ContactEmail = contacts[ts[i].ContactID].EmailAddress
+	}
+
+	if companyErr != nil {
+		return companyErr
+	}
+	return contactErr
+}
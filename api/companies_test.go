@@ -0,0 +1,80 @@
+package api
+
+import (
+	"AutoTickets/tickets"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCompanyCacheApplyNames(t *testing.T) {
+	qs := newQueryServer(t, map[string]map[int64]string{
+		"Companies": {
+			0:   `{"companyName":"unused"}`,
+			174: `{"companyName":"Acme Corp"}`,
+			175: `{"companyName":"Globex"}`,
+		},
+		"Contacts": {
+			30682910: `{"companyID":174,"firstName":"Wile","lastName":"Coyote","emailAddress":"wile@acme.example"}`,
+		},
+	})
+	client := NewClient(qs.URL, "", "", "")
+	cc := NewCompanyCache(time.Hour)
+
+	ts := []tickets.AutotaskTicket{
+		{ID: 1, CompanyID: 174, ContactID: 30682910},
+		{ID: 2, CompanyID: 175},
+		{ID: 3, CompanyID: 174, ContactID: 99},
+		{ID: 4},
+	}
+	if err := cc.ApplyNames(context.Background(), client, ts); err != nil {
+		t.Fatal(err)
+	}
+	if ts[0].CompanyName != "Acme Corp" || ts[0].ContactName != "Wile Coyote" || ts[0].ContactEmail != "wile@acme.example" {
+		t.Errorf("unexpected company / contact %+v", ts[0])
+	}
+	if ts[1].CompanyName != "Globex" || ts[1].ContactName != "" {
+		t.Errorf("unexpected company / contact %+v", ts[1])
+	}
+	if ts[2].CompanyName != "Acme Corp" || ts[2].ContactName != "" || ts[2].ContactEmail != "" {
+		t.Errorf("expected the unknown contact to stay blank, got %+v", ts[2])
+	}
+	if ts[3].CompanyName != "" {
+		t.Errorf("expected a ticket without company to stay blank, got %+v", ts[3])
+	}
+	// one batch per entity, each id once, and no lookups for unset ids
+	if got := qs.takeRequested("Companies"); fmt.Sprint(got) != "[174 175]" {
+		t.Errorf("unexpected company lookups %v", got)
+	}
+	if got := qs.takeRequested("Contacts"); fmt.Sprint(got) != "[30682910 99]" {
+		t.Errorf("unexpected contact lookups %v", got)
+	}
+
+	// resolved ids and misses are served from cache
+	cc.ApplyNames(context.Background(), client, ts)
+	if companies, contacts := qs.takeRequested("Companies"), qs.takeRequested("Contacts"); companies != nil || contacts != nil {
+		t.Errorf("expected cached lookups, requested %v %v", companies, contacts)
+	}
+}
+
+func TestCompanyCacheFailureKeepsCached(t *testing.T) {
+	qs := newQueryServer(t, map[string]map[int64]string{"Companies": {174: `{"companyName":"Acme Corp"}`}})
+	client := NewClient(qs.URL, "", "", "")
+	cc := NewCompanyCache(time.Hour)
+	ts := []tickets.AutotaskTicket{{ID: 1, CompanyID: 174}}
+	if err := cc.ApplyNames(context.Background(), client, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	qs.Close()
+	ts = []tickets.AutotaskTicket{{ID: 1, CompanyID: 174}, {ID: 2, CompanyID: 175}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := cc.ApplyNames(ctx, client, ts); err == nil {
+		t.Fatal("expected the lookup to fail")
+	}
+	if ts[0].CompanyName != "Acme Corp" || ts[1].CompanyName != "" {
+		t.Errorf("expected cached names to still apply, got %+v", ts)
+	}
+}
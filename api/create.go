@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// fields of a ticket to create. Zero optional fields are left for Autotask to default
+type NewTicket struct {
+	CompanyID   int64     `json:"companyID"`
+	ContactID   int64     `json:"contactID,omitempty"`
+	QueueID     int       `json:"queueID"`
+	Priority    int       `json:"priority"`
+	Status      int       `json:"status"`
+	IssueType   int       `json:"issueType,omitempty"`
+	Source      int       `json:"source,omitempty"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	DueDateTime time.Time `json:"dueDateTime,omitzero"`
+}
+
+// creates a ticket, returns its id. Autotask validation failures are returned as *APIError
+func (c *Client) CreateTicket(ctx context.Context, t NewTicket) (int64, error) {
+	body, err := c.send(ctx, http.MethodPost, c.baseUrl+"/v1.0/Tickets", t)
+	if err != nil {
+		return 0, fmt.Errorf("error creating ticket: %w", err)
+	}
+	id := gjson.GetBytes(body, "itemId").Int()
+	if id == 0 {
+		return 0, fmt.Errorf("error creating ticket: no id returned")
+	}
+	return id, nil
+}
+
+// returns up to 25 active companies whose name contains name
+func (c *Client) SearchCompanies(ctx context.Context, name string) ([]Company, error) {
+	q := NewQuery(Contains("companyName", name), Eq("isActive", true)).Include("id", "companyName").Max(25)
+	queryUrl, err := c.queryUrl("Companies", q)
+	if err != nil {
+		return nil, err
+	}
+	// only the first page is wanted
+	body, err := c.get(ctx, queryUrl)
+	if err != nil {
+		return nil, fmt.Errorf("error searching companies: %w", err)
+	}
+	companies := make([]Company, 0)
+	gjson.GetBytes(body, "items").ForEach(func(_, item gjson.Result) bool {
+		companies = append(companies, Company{
+			ID:          item.Get("id").Int(),
+			CompanyName: item.Get("companyName").String(),
+		})
+		return true
+	})
+	return companies, nil
+}
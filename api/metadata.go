@@ -0,0 +1,108 @@
+package api
+
+import (
+	"AutoTickets/tickets"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// default interval after which picklist metadata is reloaded
+const DefaultMetadataRefresh = time.Hour
+
+// picklist fields of an entity, keyed by lowercase field name, mapping picklist value to label
+type Picklists map[string]map[int]string
+
+// fetches picklist fields of the Tickets entity
+func (c *Client) GetTicketPicklists(ctx context.Context) (Picklists, error) {
+	body, err := c.get(ctx, c.baseUrl+"/v1.0/Tickets/entityInformation/fields")
+	if err != nil {
+		return nil, fmt.Errorf("error fetching ticket fields: %w", err)
+	}
+
+	picklists := make(Picklists)
+	gjson.GetBytes(body, "fields").ForEach(func(_, field gjson.Result) bool {
+		if !field.Get("isPickList").Bool() {
+			return true
+		}
+		labels := make(map[int]string)
+		field.Get("picklistValues").ForEach(func(_, v gjson.Result) bool {
+			labels[int(v.Get("value").Int())] = v.Get("label").String()
+			return true
+		})
+		picklists[strings.ToLower(field.Get("name").String())] = labels
+		return true
+	})
+	return picklists, nil
+}
+
+// cache of ticket picklists, loaded once and reloaded when older than the refresh interval
+type Metadata struct {
+	sync.RWMutex
+	picklists Picklists
+	loaded    time.Time
+	refresh   time.Duration
+}
+
+// returns an empty metadata cache which reloads after refresh has elapsed
+func NewMetadata(refresh time.Duration) *Metadata {
+	return &Metadata{picklists: make(Picklists), refresh: refresh}
+}
+
+// reloads picklists through c if they were never loaded or are stale.
+// On failure previously loaded labels are kept
+func (m *Metadata) Refresh(ctx context.Context, c *Client) error {
+	m.RLock()
+	fresh := !m.loaded.IsZero() && time.Since(m.loaded) < m.refresh
+	m.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	picklists, err := c.GetTicketPicklists(ctx)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.picklists = picklists
+	m.loaded = time.Now()
+	return nil
+}
+
+// returns label of value in field's picklist, "" if the field or value is unknown
+func (m *Metadata) Label(field string, value int) string {
+	m.RLock()
+	defer m.RUnlock()
+	return m.picklists[strings.ToLower(field)][value]
+}
+
+// returns a copy of field's picklist, nil if the field is unknown
+func (m *Metadata) Picklist(field string) map[int]string {
+	m.RLock()
+	defer m.RUnlock()
+	labels, ok := m.picklists[strings.ToLower(field)]
+	if !ok {
+		return nil
+	}
+	picklist := make(map[int]string, len(labels))
+	for value, label := range labels {
+		picklist[value] = label
+	}
+	return picklist
+}
+
+// sets the label fields of every ticket in ts
+func (m *Metadata) ApplyLabels(ts []tickets.AutotaskTicket) {
+	for i := range ts {
+		ts[i].PriorityLabel = m.Label("priority", ts[i].Priority)
+		ts[i].StatusLabel = m.Label("status", ts[i].Status)
+		ts[i].QueueLabel = m.Label("queueID", ts[i].QueueID)
+		ts[i].IssueTypeLabel = m.Label("issueType", ts[i].IssueType)
+		ts[i].SourceLabel = m.Label("source", ts[i].Source)
+	}
+}
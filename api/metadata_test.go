@@ -0,0 +1,111 @@
+package api
+
+import (
+	"AutoTickets/tickets"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const ticketFieldsJson = `{"fields":[
+	{"name":"id","dataType":"long","isPickList":false},
+	{"name":"priority","dataType":"integer","isPickList":true,"picklistValues":[
+		{"value":"1","label":"High","isActive":true},{"value":"2","label":"Medium","isActive":true}]},
+	{"name":"status","dataType":"integer","isPickList":true,"picklistValues":[
+		{"value":"1","label":"New","isActive":true},{"value":"8","label":"In Progress","isActive":true}]},
+	{"name":"queueID","dataType":"integer","isPickList":true,"picklistValues":[
+		{"value":"29682833","label":"Service Desk","isActive":true}]},
+	{"name":"issueType","dataType":"integer","isPickList":true,"picklistValues":[]},
+	{"name":"source","dataType":"integer","isPickList":true,"picklistValues":[
+		{"value":"2","label":"Phone","isActive":true}]}
+]}`
+
+// fake entityInformation endpoint, counting requests in calls
+func newFieldsServer(t *testing.T, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1.0/Tickets/entityInformation/fields" {
+			http.NotFound(w, r)
+			return
+		}
+		calls.Add(1)
+		fmt.Fprint(w, ticketFieldsJson)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestMetadataLabels(t *testing.T) {
+	var calls atomic.Int32
+	srv := newFieldsServer(t, &calls)
+	client := NewClient(srv.URL, "code", "secret", "user")
+	m := NewMetadata(time.Hour)
+
+	if err := m.Refresh(context.Background(), client); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ts := []tickets.AutotaskTicket{
+		{ID: 1, Priority: 1, Status: 8, QueueID: 29682833, Source: 2},
+		{ID: 2, Priority: 3, Status: 1},
+	}
+	m.ApplyLabels(ts)
+
+	if got := ts[0].PriorityLabel + " / " + ts[0].QueueLabel + " / " + ts[0].StatusLabel; got != "High / Service Desk / In Progress" {
+		t.Fatalf("unexpected labels %q", got)
+	}
+	if ts[0].SourceLabel != "Phone" {
+		t.Fatalf("unexpected source label %q", ts[0].SourceLabel)
+	}
+	if ts[1].PriorityLabel != "" || ts[1].StatusLabel != "New" || ts[1].QueueLabel != "" {
+		t.Fatalf("unknown values should have empty labels, got %+v", ts[1])
+	}
+	if m.Label("id", 1) != "" {
+		t.Fatal("non-picklist fields should have no labels")
+	}
+}
+
+func TestMetadataRefreshInterval(t *testing.T) {
+	var calls atomic.Int32
+	srv := newFieldsServer(t, &calls)
+	client := NewClient(srv.URL, "code", "secret", "user")
+
+	m := NewMetadata(time.Hour)
+	for i := 0; i < 3; i++ {
+		if err := m.Refresh(context.Background(), client); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected picklists to load once, loaded %d times", calls.Load())
+	}
+
+	m.refresh = 0
+	if err := m.Refresh(context.Background(), client); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected stale picklists to reload, loaded %d times", calls.Load())
+	}
+}
+
+func TestMetadataKeepsLabelsOnFailure(t *testing.T) {
+	var calls atomic.Int32
+	srv := newFieldsServer(t, &calls)
+	m := NewMetadata(0)
+	if err := m.Refresh(context.Background(), NewClient(srv.URL, "code", "secret", "user")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	srv.Close()
+	if err := m.Refresh(context.Background(), NewClient(srv.URL, "code", "secret", "user")); err == nil {
+		t.Fatal("expected error from closed server")
+	}
+	if m.Label("priority", 1) != "High" {
+		t.Fatal("labels should survive a failed refresh")
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"AutoTickets/tickets"
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// ticket note defaults: "Task Notes" type, published to all Autotask users
+const (
+	noteTypeTaskNotes = 1
+	notePublishAll    = 1
+)
+
+// posts a note to ticketID
+func (c *Client) AddTicketNote(ctx context.Context, ticketID int64, title, description string) error {
+	note := map[string]any{
+		"ticketID":    ticketID,
+		"title":       title,
+		"description": description,
+		"noteType":    noteTypeTaskNotes,
+		"publish":     notePublishAll,
+	}
+	noteUrl := fmt.Sprintf("%s/v1.0/Tickets/%d/Notes", c.baseUrl, ticketID)
+	if _, err := c.send(ctx, http.MethodPost, noteUrl, note); err != nil {
+		return fmt.Errorf("error adding note to ticket %d: %w", ticketID, err)
+	}
+	return nil
+}
+
+// sets the status of ticketID, returns the updated ticket
+func (c *Client) SetTicketStatus(ctx context.Context, ticketID int64, status int) (tickets.AutotaskTicket, error) {
+	update := map[string]any{
+		"id":     ticketID,
+		"status": status,
+	}
+	if _, err := c.send(ctx, http.MethodPatch, c.baseUrl+"/v1.0/Tickets", update); err != nil {
+		return tickets.AutotaskTicket{}, fmt.Errorf("error setting status of ticket %d: %w", ticketID, err)
+	}
+	return c.GetTicket(ctx, ticketID)
+}
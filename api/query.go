@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/tidwall/gjson"
+)
+
+// Autotask query filter operators
+const (
+	OpEq         = "eq"
+	OpNotEq      = "noteq"
+	OpGt         = "gt"
+	OpGte        = "gte"
+	OpLt         = "lt"
+	OpLte        = "lte"
+	OpBeginsWith = "beginsWith"
+	OpEndsWith   = "endsWith"
+	OpContains   = "contains"
+	OpExist      = "exist"
+	OpNotExist   = "notExist"
+	OpIn         = "in"
+	OpNotIn      = "notIn"
+	OpAnd        = "and"
+	OpOr         = "or"
+)
+
+// largest page size Autotask accepts for MaxRecords
+const maxRecordsLimit = 500
+
+// largest number of ids sent in a single "in" filter
+const maxIdsPerQuery = 500
+
+// a single field condition, or an and/or group of filters
+type Filter struct {
+	Op    string   `json:"op"`
+	Field string   `json:"field,omitempty"`
+	Value any      `json:"value,omitempty"`
+	Udf   bool     `json:"udf,omitempty"`
+	Items []Filter `json:"items,omitempty"`
+}
+
+// Autotask entity query, serialized to the search parameter. Top level filters are and-ed together
+type Query struct {
+	Filter        []Filter `json:"filter"`
+	IncludeFields []string `json:"IncludeFields,omitempty"`
+	MaxRecords    int      `json:"MaxRecords,omitempty"`
+}
+
+// field equals value
+func Eq(field string, value any) Filter { return Filter{Op: OpEq, Field: field, Value: value} }
+
+// field does not equal value
+func NotEq(field string, value any) Filter { return Filter{Op: OpNotEq, Field: field, Value: value} }
+
+// field is greater than value
+func Gt(field string, value any) Filter { return Filter{Op: OpGt, Field: field, Value: value} }
+
+// field is greater than or equal to value
+func Gte(field string, value any) Filter { return Filter{Op: OpGte, Field: field, Value: value} }
+
+// field is less than value
+func Lt(field string, value any) Filter { return Filter{Op: OpLt, Field: field, Value: value} }
+
+// field is less than or equal to value
+func Lte(field string, value any) Filter { return Filter{Op: OpLte, Field: field, Value: value} }
+
+// string field starts with value
+func BeginsWith(field, value string) Filter {
+	return Filter{Op: OpBeginsWith, Field: field, Value: value}
+}
+
+// string field ends with value
+func EndsWith(field, value string) Filter { return Filter{Op: OpEndsWith, Field: field, Value: value} }
+
+// string field contains value
+func Contains(field, value string) Filter { return Filter{Op: OpContains, Field: field, Value: value} }
+
+// field has a value
+func Exist(field string) Filter { return Filter{Op: OpExist, Field: field} }
+
+// field is empty
+func NotExist(field string) Filter { return Filter{Op: OpNotExist, Field: field} }
+
+// field equals one of values
+func In[T any](field string, values ...T) Filter {
+	return Filter{Op: OpIn, Field: field, Value: values}
+}
+
+// field equals none of values
+func NotIn[T any](field string, values ...T) Filter {
+	return Filter{Op: OpNotIn, Field: field, Value: values}
+}
+
+// all of filters match
+func And(filters ...Filter) Filter { return Filter{Op: OpAnd, Items: filters} }
+
+// any of filters match
+func Or(filters ...Filter) Filter { return Filter{Op: OpOr, Items: filters} }
+
+// marks a condition as applying to a user-defined field
+func (f Filter) UDF() Filter {
+	f.Udf = true
+	return f
+}
+
+// returns a query matching all of filters
+func NewQuery(filters ...Filter) *Query {
+	return &Query{Filter: filters}
+}
+
+// limits returned entities to fields (id is always returned)
+func (q *Query) Include(fields ...string) *Query {
+	q.IncludeFields = append(q.IncludeFields, fields...)
+	return q
+}
+
+// sets the page size, 1-500. Remaining records are reached through pageDetails.nextPageUrl
+func (q *Query) Max(records int) *Query {
+	q.MaxRecords = records
+	return q
+}
+
+// serializes the query to the JSON expected by the search parameter (not url encoded)
+func (q *Query) Encode() (string, error) {
+	if len(q.Filter) == 0 {
+		return "", fmt.Errorf("query requires at least one filter")
+	}
+	if q.MaxRecords < 0 || q.MaxRecords > maxRecordsLimit {
+		return "", fmt.Errorf("query MaxRecords %d out of range (1-%d)", q.MaxRecords, maxRecordsLimit)
+	}
+	for _, f := range q.Filter {
+		if err := f.validate(); err != nil {
+			return "", err
+		}
+	}
+	data, err := json.Marshal(q)
+	if err != nil {
+		return "", fmt.Errorf("error encoding query: %w", err)
+	}
+	return string(data), nil
+}
+
+// returns the url of entity's query endpoint with q url encoded into the search parameter
+func (c *Client) queryUrl(entity string, q *Query) (string, error) {
+	search, err := q.Encode()
+	if err != nil {
+		return "", err
+	}
+	return c.baseUrl + "/v1.0/" + url.PathEscape(entity) + "/query?search=" + url.QueryEscape(search), nil
+}
+
+// runs q against entity and returns matching items from every page
+func (c *Client) query(ctx context.Context, entity string, q *Query) ([]gjson.Result, error) {
+	queryUrl, err := c.queryUrl(entity, q)
+	if err != nil {
+		return nil, err
+	}
+	return c.getAllPages(ctx, queryUrl)
+}
+
+// checks filter structure: groups need items, conditions need a field
+func (f Filter) validate() error {
+	switch f.Op {
+	case OpAnd, OpOr:
+		if len(f.Items) == 0 {
+			return fmt.Errorf("%q filter group has no items", f.Op)
+		}
+		for _, item := range f.Items {
+			if err := item.validate(); err != nil {
+				return err
+			}
+		}
+	case "":
+		return fmt.Errorf("filter on %q has no op", f.Field)
+	default:
+		if f.Field == "" {
+			return fmt.Errorf("%q filter has no field", f.Op)
+		}
+	}
+	return nil
+}
+
+// fetches entities by id, batching ids into "in" filters of up to maxIdsPerQuery ids
+func (c *Client) queryByIds(ctx context.Context, entity string, ids []int64, fields ...string) ([]gjson.Result, error) {
+	var items []gjson.Result
+	for start := 0; start < len(ids); start += maxIdsPerQuery {
+		end := min(start+maxIdsPerQuery, len(ids))
+		q := NewQuery(In("id", ids[start:end]...)).Max(maxRecordsLimit)
+		if len(fields) > 0 {
+			q.Include(fields...)
+		}
+		batch, err := c.query(ctx, entity, q)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, batch...)
+	}
+	return items, nil
+}
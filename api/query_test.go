@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestQueryEncode(t *testing.T) {
+	tests := []struct {
+		name  string
+		query *Query
+		want  string
+	}{
+		{
+			name:  "single condition",
+			query: NewQuery(NotEq("status", 5)),
+			want:  `{"filter":[{"op":"noteq","field":"status","value":5}]}`,
+		},
+		{
+			name: "nested and / or",
+			query: NewQuery(Or(
+				And(Eq("queueID", 29683412), Exist("assignedResourceID")),
+				Contains("title", "printer").UDF(),
+			)).Max(100),
+			want: `{"filter":[{"op":"or","items":[{"op":"and","items":[{"op":"eq","field":"queueID","value":29683412},` +
+				`{"op":"exist","field":"assignedResourceID"}]},{"op":"contains","field":"title","value":"printer","udf":true}]}],"MaxRecords":100}`,
+		},
+		{
+			name:  "in / not in",
+			query: NewQuery(In[int64]("id", 1, 2, 3), NotIn("status", "5", "7")).Include("id", "title"),
+			want:  `{"filter":[{"op":"in","field":"id","value":[1,2,3]},{"op":"notIn","field":"status","value":["5","7"]}],"IncludeFields":["id","title"]}`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := tt.query.Encode()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\n got  %s\n want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQueryEncodeInvalid(t *testing.T) {
+	tests := map[string]*Query{
+		"no filters":           NewQuery(),
+		"empty group":          NewQuery(And()),
+		"empty nested group":   NewQuery(Or(Eq("id", 1), And())),
+		"condition no field":   NewQuery(Eq("", 1)),
+		"nested no field":      NewQuery(And(Exist(""))),
+		"no op":                NewQuery(Filter{Field: "id"}),
+		"max records too high": NewQuery(Eq("id", 1)).Max(maxRecordsLimit + 1),
+		"max records negative": NewQuery(Eq("id", 1)).Max(-1),
+	}
+	for name, q := range tests {
+		if got, err := q.Encode(); err == nil {
+			t.Errorf("%s: expected error, got %s", name, got)
+		}
+	}
+}
+
+func TestQueryUrlEscaping(t *testing.T) {
+	c := NewClient("https://webservices14.autotask.net/atservicesrest/", "", "", "")
+	q := NewQuery(Eq("title", `a&b=c #"100%" + é`))
+	got, err := c.queryUrl("Tickets", q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := "https://webservices14.autotask.net/atservicesrest/v1.0/Tickets/query?search="
+	if !strings.HasPrefix(got, prefix) {
+		t.Fatalf("unexpected url %s", got)
+	}
+	u, err := url.Parse(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Query()) != 1 || u.Fragment != "" {
+		t.Errorf("value leaked out of the search parameter: %s", got)
+	}
+	if value := gjson.Get(u.Query().Get("search"), "filter.0.value").String(); value != `a&b=c #"100%" + é` {
+		t.Errorf("value did not round trip, got %q", value)
+	}
+
+	if _, err := c.queryUrl("Tickets", NewQuery()); err == nil {
+		t.Error("expected invalid query to be refused")
+	}
+}
+
+func TestQueryByIdsBatches(t *testing.T) {
+	for _, tt := range []struct {
+		ids     int
+		batches []int
+	}{
+		{ids: 0, batches: nil},
+		{ids: 1, batches: []int{1}},
+		{ids: 500, batches: []int{500}},
+		{ids: 501, batches: []int{500, 1}},
+		{ids: 1000, batches: []int{500, 500}},
+	} {
+		var mu sync.Mutex
+		var batches []int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ids := gjson.Get(r.URL.Query().Get("search"), "filter.0.value").Array()
+			mu.Lock()
+			batches = append(batches, len(ids))
+			mu.Unlock()
+			items := make([]string, len(ids))
+			for i, id := range ids {
+				items[i] = fmt.Sprintf(`{"id":%d}`, id.Int())
+			}
+			fmt.Fprintf(w, `{"items":[%s],"pageDetails":{"nextPageUrl":null}}`, strings.Join(items, ","))
+		}))
+
+		ids := make([]int64, tt.ids)
+		for i := range ids {
+			ids[i] = int64(i + 1)
+		}
+		items, err := NewClient(srv.URL, "", "", "").queryByIds(context.Background(), "Resources", ids, "id")
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%d ids: unexpected error: %v", tt.ids, err)
+		}
+		if len(items) != tt.ids {
+			t.Errorf("%d ids: expected every item, got %d", tt.ids, len(items))
+		}
+		if fmt.Sprint(batches) != fmt.Sprint(tt.batches) {
+			t.Errorf("%d ids: expected batches %v, got %v", tt.ids, tt.batches, batches)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"AutoTickets/tickets"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// default time a resolved resource is cached
+const DefaultResourceTtl = 6 * time.Hour
+
+// time after which the index of active resources by email is reloaded
+const activeRefresh = 15 * time.Minute
+
+// Autotask resource (technician)
+type Resource struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	IsActive  bool   `json:"isActive"`
+}
+
+// returns the resource's display name
+func (r Resource) Name() string {
+	return strings.TrimSpace(r.FirstName + " " + r.LastName)
+}
+
+// decodes a single Resources entity
+func parseResource(r gjson.Result) Resource {
+	return Resource{
+		ID:        r.Get("id").Int(),
+		FirstName: r.Get("firstName").String(),
+		LastName:  r.Get("lastName").String(),
+		Email:     r.Get("email").String(),
+		IsActive:  r.Get("isActive").Bool(),
+	}
+}
+
+// fetches resources by id
+func (c *Client) GetResources(ctx context.Context, ids []int64) (map[int64]Resource, error) {
+	items, err := c.queryByIds(ctx, "Resources", ids, "id", "firstName", "lastName", "email", "isActive")
+	if err != nil {
+		return nil, fmt.Errorf("error fetching resources: %w", err)
+	}
+	resources := make(map[int64]Resource, len(items))
+	for _, item := range items {
+		r := parseResource(item)
+		resources[r.ID] = r
+	}
+	return resources, nil
+}
+
+// resources cached by id, and active resources indexed by email
+type ResourceCache struct {
+	sync.RWMutex
+	cache *ttlCache[Resource]
+	// lowercased email to resource id, 0 if several active resources share the email
+	emails       map[string]int64
+	emailsLoaded time.Time
+}
+
+// returns an empty resource cache whose entries expire after ttl
+func NewResourceCache(ttl time.Duration) *ResourceCache {
+	return &ResourceCache{cache: newTtlCache[Resource](ttl)}
+}
+
+// returns resources for ids, fetching uncached or expired ones through c
+func (rc *ResourceCache) Resolve(ctx context.Context, c *Client, ids []int64) (map[int64]Resource, error) {
+	return rc.cache.resolve(ids, func(ids []int64) (map[int64]Resource, error) {
+		return c.GetResources(ctx, ids)
+	})
+}
+
+// reloads the email index from the active resources through c, if it was never loaded or is
+// older than activeRefresh. Loaded resources are cached by id too. On failure the previous index is kept
+func (rc *ResourceCache) RefreshActive(ctx context.Context, c *Client) error {
+	rc.RLock()
+	fresh := !rc.emailsLoaded.IsZero() && rc.cache.now().Sub(rc.emailsLoaded) < activeRefresh
+	rc.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	resources, err := c.GetActiveResources(ctx)
+	if err != nil {
+		return err
+	}
+	emails := make(map[string]int64, len(resources))
+	for _, r := range resources {
+		rc.cache.set(r.ID, r, rc.cache.ttl)
+		email := strings.ToLower(strings.TrimSpace(r.Email))
+		if email == "" {
+			continue
+		}
+		if _, shared := emails[email]; shared {
+			emails[email] = 0
+		} else {
+			emails[email] = r.ID
+		}
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	rc.emails = emails
+	rc.emailsLoaded = rc.cache.now()
+	return nil
+}
+
+// returns the id of the active resource with email (case-insensitive) from the index loaded by
+// RefreshActive, false if there is none or several resources share it
+func (rc *ResourceCache) ActiveByEmail(email string) (int64, bool) {
+	rc.RLock()
+	defer rc.RUnlock()
+	id := rc.emails[strings.ToLower(strings.TrimSpace(email))]
+	return id, id != 0
+}
+
+// sets assigned resource name and email on every assigned ticket in ts.
+// On lookup failure tickets resolved from cache are still named
+func (rc *ResourceCache) ApplyNames(ctx context.Context, c *Client, ts []tickets.AutotaskTicket) error {
+	var ids []int64
+	for _, t := range ts {
+		if id, ok := resourceId(t); ok {
+			ids = append(ids, id)
+		}
+	}
+	resources, err := rc.Resolve(ctx, c, ids)
+	for i := range ts {
+		if id, ok := resourceId(ts[i]); ok {
+			ts[i].AssignedResourceName = resources[id].Name()
+			ts[i].AssignedResourceEmail = resources[id].Email
+		}
+	}
+	return err
+}
+
+// parses a ticket's assigned resource id, false if unassigned
+func resourceId(t tickets.AutotaskTicket) (int64, bool) {
+	if t.AssignedResourceID == "" {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(t.AssignedResourceID, 10, 64)
+	return id, err == nil
+}
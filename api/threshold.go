@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// how often threshold information is re-read from Autotask
+const thresholdRefreshInterval = 5 * time.Minute
+
+// Autotask request threshold: request limit per timeframe, and requests counted so far
+type ThresholdInfo struct {
+	Limit            int `json:"externalRequestThreshold"`
+	TimeframeMinutes int `json:"requestThresholdTimeframe"`
+	Count            int `json:"currentTimeframeRequestCount"`
+}
+
+// fetches the tenant's current request threshold usage
+func (c *Client) GetThresholdInfo(ctx context.Context) (ThresholdInfo, error) {
+	body, err := c.get(ctx, c.baseUrl+"/v1.0/ThresholdInformation")
+	if err != nil {
+		return ThresholdInfo{}, fmt.Errorf("error fetching threshold information: %w", err)
+	}
+	return ThresholdInfo{
+		Limit:            int(gjson.GetBytes(body, "externalRequestThreshold").Int()),
+		TimeframeMinutes: int(gjson.GetBytes(body, "requestThresholdTimeframe").Int()),
+		Count:            int(gjson.GetBytes(body, "currentTimeframeRequestCount").Int()),
+	}, nil
+}
+
+// estimated request usage against the threshold
+type ThresholdUsage struct {
+	Count int `json:"count"`
+	Limit int `json:"limit"`
+}
+
+// fraction of the threshold used, 0 when the limit is unknown
+func (u ThresholdUsage) Ratio() float64 {
+	if u.Limit <= 0 {
+		return 0
+	}
+	return float64(u.Count) / float64(u.Limit)
+}
+
+// tracks request threshold usage: the count last reported by Autotask plus requests
+// this server made since, and the server's own requests over the last timeframe
+type ThresholdTracker struct {
+	sync.Mutex
+	info      ThresholdInfo
+	infoTime  time.Time
+	sinceInfo int
+	requests  []time.Time
+}
+
+// returns an empty tracker
+func NewThresholdTracker() *ThresholdTracker {
+	return &ThresholdTracker{}
+}
+
+// records a request made by this server
+func (tt *ThresholdTracker) Record() {
+	tt.Lock()
+	defer tt.Unlock()
+	now := time.Now()
+	tt.sinceInfo++
+	tt.requests = append(tt.requests, now)
+	tt.prune(now)
+}
+
+// re-reads threshold information through c if it is older than thresholdRefreshInterval
+func (tt *ThresholdTracker) Refresh(ctx context.Context, c *Client) error {
+	tt.Lock()
+	fresh := !tt.infoTime.IsZero() && time.Since(tt.infoTime) < thresholdRefreshInterval
+	tt.Unlock()
+	if fresh {
+		return nil
+	}
+
+	info, err := c.GetThresholdInfo(ctx)
+	if err != nil {
+		return err
+	}
+	tt.Lock()
+	defer tt.Unlock()
+	tt.info = info
+	tt.infoTime = time.Now()
+	tt.sinceInfo = 0
+	return nil
+}
+
+// returns estimated usage. Before threshold information is read, only this server's requests are counted
+func (tt *ThresholdTracker) Usage() ThresholdUsage {
+	tt.Lock()
+	defer tt.Unlock()
+	tt.prune(time.Now())
+	if tt.infoTime.IsZero() {
+		return ThresholdUsage{Count: len(tt.requests)}
+	}
+	return ThresholdUsage{
+		Count: max(tt.info.Count+tt.sinceInfo, len(tt.requests)),
+		Limit: tt.info.Limit,
+	}
+}
+
+// returns the interval to wait before the next poll: base while usage is low,
+// stretched as usage nears the threshold
+func (tt *ThresholdTracker) PollInterval(base time.Duration) time.Duration {
+	ratio := tt.Usage().Ratio()
+	switch {
+	case ratio >= 0.9:
+		return base * 8
+	case ratio >= 0.75:
+		return base * 4
+	case ratio >= 0.5:
+		return base * 2
+	}
+	return base
+}
+
+// drops own requests older than the threshold timeframe. Caller holds the lock
+func (tt *ThresholdTracker) prune(now time.Time) {
+	timeframe := time.Hour
+	if tt.info.TimeframeMinutes > 0 {
+		timeframe = time.Duration(tt.info.TimeframeMinutes) * time.Minute
+	}
+	cutoff := now.Add(-timeframe)
+	i := 0
+	for i < len(tt.requests) && tt.requests[i].Before(cutoff) {
+		i++
+	}
+	tt.requests = tt.requests[i:]
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPollIntervalSteps(t *testing.T) {
+	base := 30 * time.Second
+	tests := []struct {
+		count, limit int
+		want         time.Duration
+	}{
+		{count: 0, limit: 10000, want: base},
+		{count: 4999, limit: 10000, want: base},
+		{count: 5000, limit: 10000, want: base * 2},
+		{count: 7499, limit: 10000, want: base * 2},
+		{count: 7500, limit: 10000, want: base * 4},
+		{count: 8999, limit: 10000, want: base * 4},
+		{count: 9000, limit: 10000, want: base * 8},
+		{count: 12000, limit: 10000, want: base * 8},
+		// limit unknown
+		{count: 9000, limit: 0, want: base},
+	}
+	for _, tt := range tests {
+		tracker := NewThresholdTracker()
+		tracker.info = ThresholdInfo{Limit: tt.limit, TimeframeMinutes: 60, Count: tt.count}
+		tracker.infoTime = time.Now()
+		if got := tracker.PollInterval(base); got != tt.want {
+			t.Errorf("%d/%d: expected %v, got %v", tt.count, tt.limit, tt.want, got)
+		}
+	}
+}
+
+func TestThresholdUsage(t *testing.T) {
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1.0/ThresholdInformation" {
+			http.NotFound(w, r)
+			return
+		}
+		fetches.Add(1)
+		fmt.Fprint(w, `{"externalRequestThreshold":10000,"requestThresholdTimeframe":60,"currentTimeframeRequestCount":4998}`)
+	}))
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, "", "", "")
+
+	info, err := client.GetThresholdInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info != (ThresholdInfo{Limit: 10000, TimeframeMinutes: 60, Count: 4998}) {
+		t.Errorf("unexpected threshold info %+v", info)
+	}
+
+	tracker := NewThresholdTracker()
+	// before threshold information is read, only own requests count and the limit is unknown
+	tracker.Record()
+	if usage := tracker.Usage(); usage != (ThresholdUsage{Count: 1}) || usage.Ratio() != 0 {
+		t.Errorf("unexpected usage before refresh %+v", usage)
+	}
+
+	client.SetThresholdTracker(tracker)
+	if err := tracker.Refresh(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+	// the refresh request itself is counted by Autotask's count, not on top of it
+	if usage := tracker.Usage(); usage != (ThresholdUsage{Count: 4998, Limit: 10000}) {
+		t.Errorf("unexpected usage after refresh %+v", usage)
+	}
+	tracker.Record()
+	tracker.Record()
+	if usage := tracker.Usage(); usage.Count != 5000 || tracker.PollInterval(time.Second) != 2*time.Second {
+		t.Errorf("expected requests since the refresh to be added, got %+v", usage)
+	}
+
+	if err := tracker.Refresh(context.Background(), client); err != nil || fetches.Load() != 2 {
+		t.Errorf("expected fresh threshold information not to be fetched again, fetched %d times", fetches.Load())
+	}
+}
+
+func TestThresholdOwnRequestsPruned(t *testing.T) {
+	tracker := NewThresholdTracker()
+	tracker.info = ThresholdInfo{Limit: 100, TimeframeMinutes: 60}
+	tracker.infoTime = time.Now()
+	tracker.requests = []time.Time{time.Now().Add(-2 * time.Hour), time.Now().Add(-30 * time.Minute)}
+	// own requests within the timeframe count when Autotask reports fewer
+	if usage := tracker.Usage(); usage.Count != 1 {
+		t.Errorf("expected requests older than the timeframe to be dropped, got %+v", usage)
+	}
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// request retry / timeout settings
+const (
+	requestTimeout = 30 * time.Second
+	maxAttempts    = 4
+	baseBackoff    = 500 * time.Millisecond
+	maxBackoff     = 30 * time.Second
+	// longer Retry-After waits are not slept through; the request fails and is retried next poll
+	maxRetryAfter = time.Minute
+)
+
+// circuit breaker settings
+const (
+	breakerThreshold = 5
+	breakerCooldown  = time.Minute
+)
+
+// circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// returned without contacting the API while the circuit breaker is open
+var ErrCircuitOpen = errors.New("API circuit breaker open, request not sent")
+
+// non-2xx response from the API, with any error messages Autotask returned
+type APIError struct {
+	StatusCode int
+	Status     string
+	Errors     []string
+	retryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if len(e.Errors) == 0 {
+		return "error response from API: " + e.Status
+	}
+	return "error response from API: " + e.Status + ": " + strings.Join(e.Errors, "; ")
+}
+
+// true for responses worth retrying: throttling and server errors
+func (e *APIError) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// client health, as seen by the circuit breaker
+type Health struct {
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	BreakerState        string `json:"breakerState"`
+}
+
+// circuit breaker: opens after breakerThreshold consecutive failed requests, rejecting
+// requests until breakerCooldown passes, then lets a single trial request through (half-open)
+type breaker struct {
+	sync.Mutex
+	failures int
+	state    string
+	openedAt time.Time
+	trial    bool
+}
+
+// returns ErrCircuitOpen if a request may not be sent now. Otherwise returns release, which the
+// request calls once it is done: if it was the half-open trial and ended without a success or
+// failure being recorded (e.g. its context was cancelled), the next request may try instead
+func (b *breaker) allow() (func(), error) {
+	b.Lock()
+	defer b.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < breakerCooldown {
+			return nil, ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+	case BreakerHalfOpen:
+		if b.trial {
+			return nil, ErrCircuitOpen
+		}
+	default:
+		return func() {}, nil
+	}
+	b.trial = true
+	return b.releaseTrial, nil
+}
+
+// frees the half-open trial, if it is still held
+func (b *breaker) releaseTrial() {
+	b.Lock()
+	defer b.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.trial = false
+	}
+}
+
+// records a successful request, closing the breaker
+func (b *breaker) success() {
+	b.Lock()
+	defer b.Unlock()
+	b.failures = 0
+	b.state = BreakerClosed
+	b.trial = false
+}
+
+// records a failed request, opening the breaker once failures reach the threshold
+func (b *breaker) failure() {
+	b.Lock()
+	defer b.Unlock()
+	b.failures++
+	b.trial = false
+	if b.state == BreakerHalfOpen || b.failures >= breakerThreshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// returns failure count and state
+func (b *breaker) health() Health {
+	b.Lock()
+	defer b.Unlock()
+	state := b.state
+	if state == "" {
+		state = BreakerClosed
+	}
+	return Health{ConsecutiveFailures: b.failures, BreakerState: state}
+}
+
+// sends a request, retrying temporary failures with exponential backoff. Returns the body of a 2xx response.
+// POST is only retried when throttled, as other failures may have been processed
+func (c *Client) do(ctx context.Context, method, requestUrl string, body []byte) ([]byte, error) {
+	release, err := c.breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	for attempt := 1; ; attempt++ {
+		var respBody []byte
+		respBody, err = c.attempt(ctx, method, requestUrl, body)
+		if err == nil {
+			c.breaker.success()
+			return respBody, nil
+		}
+		if attempt == maxAttempts || !retryable(method, err) {
+			break
+		}
+
+		wait := backoff(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.retryAfter > 0 {
+			if apiErr.retryAfter > maxRetryAfter {
+				break
+			}
+			wait = apiErr.retryAfter
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	// client errors mean the API is reachable; only count failures of the API itself
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && !apiErr.temporary() {
+		c.breaker.success()
+	} else if ctx.Err() == nil {
+		c.breaker.failure()
+	}
+	return nil, err
+}
+
+// sends a single request, setting credentials when the client has them
+func (c *Client) attempt(ctx context.Context, method, requestUrl string, body []byte) ([]byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	if c.integrationCode != "" {
+		req.Header.Set("ApiIntegrationCode", c.integrationCode)
+		req.Header.Set("Secret", c.secret)
+		req.Header.Set("UserName", c.username)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.tracker != nil {
+		c.tracker.Record()
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making %s request: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+		gjson.GetBytes(respBody, "errors").ForEach(func(_, e gjson.Result) bool {
+			apiErr.Errors = append(apiErr.Errors, e.String())
+			return true
+		})
+		return nil, apiErr
+	}
+	return respBody, nil
+}
+
+// true if err from a method request may succeed on retry
+func retryable(method string, err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if method == http.MethodPost {
+			return apiErr.StatusCode == http.StatusTooManyRequests
+		}
+		return apiErr.temporary()
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// network errors
+	return method != http.MethodPost
+}
+
+// exponential backoff for attempt (1-based) with jitter, between half and all of the backoff
+func backoff(attempt int) time.Duration {
+	d := min(baseBackoff<<(attempt-1), maxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+// parses a Retry-After header, either delay seconds or an HTTP date. 0 if absent or invalid
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreakerStates(t *testing.T) {
+	b := &breaker{}
+	for i := 0; i < breakerThreshold-1; i++ {
+		b.failure()
+	}
+	if _, err := b.allow(); err != nil || b.health().BreakerState != BreakerClosed {
+		t.Fatalf("expected the breaker to stay closed below the threshold, got %+v", b.health())
+	}
+	b.failure()
+	if h := b.health(); h.BreakerState != BreakerOpen || h.ConsecutiveFailures != breakerThreshold {
+		t.Fatalf("expected the breaker to open at the threshold, got %+v", h)
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected requests to be refused while open, got %v", err)
+	}
+
+	// after the cooldown a single trial goes through
+	b.openedAt = time.Now().Add(-breakerCooldown)
+	release, err := b.allow()
+	if err != nil || b.health().BreakerState != BreakerHalfOpen {
+		t.Fatalf("expected a half-open trial, got %v %+v", err, b.health())
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("expected a second request to wait for the trial")
+	}
+	b.failure()
+	release()
+	if h := b.health(); h.BreakerState != BreakerOpen {
+		t.Fatalf("expected a failed trial to reopen the breaker, got %+v", h)
+	}
+
+	b.openedAt = time.Now().Add(-breakerCooldown)
+	release, _ = b.allow()
+	b.success()
+	release()
+	if h := b.health(); h.BreakerState != BreakerClosed || h.ConsecutiveFailures != 0 {
+		t.Fatalf("expected a successful trial to close the breaker, got %+v", h)
+	}
+}
+
+func TestBreakerTrialReleased(t *testing.T) {
+	b := &breaker{state: BreakerOpen, openedAt: time.Now().Add(-breakerCooldown)}
+	release, err := b.allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the trial ends without an outcome, e.g. its context was cancelled
+	release()
+	if _, err := b.allow(); err != nil {
+		t.Fatalf("expected the next request to become the trial, got %v", err)
+	}
+
+	// a request that started before the breaker opened can't free the current trial
+	b = &breaker{}
+	stale, _ := b.allow()
+	for i := 0; i < breakerThreshold; i++ {
+		b.failure()
+	}
+	b.openedAt = time.Now().Add(-breakerCooldown)
+	b.allow()
+	stale()
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the trial to still be held, got %v", err)
+	}
+}
+
+func TestCancelledTrialDoesNotWedgeBreaker(t *testing.T) {
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+	c := NewClient(srv.URL, "", "", "")
+	c.breaker = &breaker{state: BreakerOpen, openedAt: time.Now().Add(-breakerCooldown)}
+
+	// the trial's context ends while it backs off
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.get(ctx, srv.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the trial to time out, got %v", err)
+	}
+
+	healthy.Store(true)
+	if _, err := c.get(context.Background(), srv.URL); err != nil {
+		t.Fatalf("expected the next request to be tried, got %v", err)
+	}
+	if state := c.Health().BreakerState; state != BreakerClosed {
+		t.Fatalf("expected the breaker to close, got %s", state)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 1; attempt <= 10; attempt++ {
+		full := min(baseBackoff<<(attempt-1), maxBackoff)
+		for i := 0; i < 50; i++ {
+			if d := backoff(attempt); d < full/2 || d > full {
+				t.Fatalf("attempt %d: %v outside [%v, %v]", attempt, d, full/2, full)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":                              0,
+		"0":                             0,
+		"7":                             7 * time.Second,
+		"-3":                            0,
+		"soon":                          0,
+		"Wed, 21 Oct 2015 07:28:00 GMT": 0,
+	}
+	for value, want := range tests {
+		if got := parseRetryAfter(value); got != want {
+			t.Errorf("%q: expected %v, got %v", value, want, got)
+		}
+	}
+	future := time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(future); got < 80*time.Second || got > 90*time.Second {
+		t.Errorf("http date: expected about 90s, got %v", got)
+	}
+}
+
+// server answering each request with the next status in statuses (200 once they run out), with retryAfter set on errors
+func newStatusServer(t *testing.T, retryAfter string, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		if n <= len(statuses) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			http.Error(w, `{"errors":["nope"]}`, statuses[n-1])
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		retryAfter string
+		statuses   []int
+		wantErr    bool
+		requests   int32
+	}{
+		{name: "GET retried after server errors", method: http.MethodGet, statuses: []int{500, 502}, requests: 3},
+		{name: "GET retried when throttled", method: http.MethodGet, retryAfter: "1", statuses: []int{429}, requests: 2},
+		{name: "GET gives up after maxAttempts", method: http.MethodGet, statuses: []int{500, 500, 500, 500, 500}, wantErr: true, requests: maxAttempts},
+		{name: "GET client errors not retried", method: http.MethodGet, statuses: []int{404}, wantErr: true, requests: 1},
+		{name: "POST not retried after server error", method: http.MethodPost, statuses: []int{500}, wantErr: true, requests: 1},
+		{name: "POST retried when throttled", method: http.MethodPost, retryAfter: "1", statuses: []int{429}, requests: 2},
+		{name: "PATCH retried after server error", method: http.MethodPatch, statuses: []int{503}, requests: 2},
+		{name: "long Retry-After not waited for", method: http.MethodGet, retryAfter: "120", statuses: []int{429}, wantErr: true, requests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// backoffs add up to seconds, so the cases wait in parallel
+			t.Parallel()
+			srv, requests := newStatusServer(t, tt.retryAfter, tt.statuses...)
+			c := NewClient(srv.URL, "", "", "")
+			var body []byte
+			if tt.method != http.MethodGet {
+				body = []byte(`{}`)
+			}
+			_, err := c.do(context.Background(), tt.method, srv.URL, body)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error %v", err)
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("expected %d requests, got %d", tt.requests, got)
+			}
+		})
+	}
+}
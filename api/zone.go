@@ -0,0 +1,32 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/tidwall/gjson"
+)
+
+// zone lookup endpoint, reachable from any zone and requiring no credentials
+const zoneInformationUrl = "https://webservices.autotask.net/atservicesrest/v1.0/zoneInformation"
+
+// looks up the REST base url of the Autotask zone hosting username's tenant
+func ResolveZone(ctx context.Context, username string) (string, error) {
+	return resolveZone(ctx, zoneInformationUrl, username)
+}
+
+// queries zoneUrl for username's zone, returns the zone's REST base url
+func resolveZone(ctx context.Context, zoneUrl, username string) (string, error) {
+	// zone lookups are anonymous: a client without credentials sends no auth headers
+	anonymous := NewClient("", "", "", "")
+	body, err := anonymous.get(ctx, zoneUrl+"?user="+url.QueryEscape(username))
+	if err != nil {
+		return "", fmt.Errorf("error resolving zone: %w", err)
+	}
+	baseUrl := gjson.GetBytes(body, "url").String()
+	if baseUrl == "" {
+		return "", fmt.Errorf("error resolving zone: no url returned for user %q", username)
+	}
+	return baseUrl, nil
+}
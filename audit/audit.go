@@ -0,0 +1,55 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// a single audited action
+type Entry struct {
+	Time time.Time `json:"time"`
+	// username of the signed in user, "oidc:" and the subject for single sign-on users
+	User string `json:"user,omitempty"`
+	// name and id of the resource the action was performed as
+	Actor      string `json:"actor"`
+	ResourceID int64  `json:"resourceID,omitempty"`
+	// true when the resource is whatever the client said it was, rather than linked to the user
+	SelfReported bool   `json:"selfReported,omitempty"`
+	RemoteAddr   string `json:"remoteAddr,omitempty"`
+	Action       string `json:"action"`
+	TicketID     int64  `json:"ticketID"`
+	Detail       string `json:"detail,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// append-only audit log, one JSON entry per line, and mutex
+type Log struct {
+	sync.Mutex
+	FilePath string
+}
+
+// appends e to the log, stamping it with the current time if unset
+func (l *Log) Record(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error encoding audit entry: %w", err)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	f, err := os.OpenFile(l.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing audit log: %w", err)
+	}
+	return nil
+}
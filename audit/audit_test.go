@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordAppendsJsonLines(t *testing.T) {
+	l := &Log{FilePath: filepath.Join(t.TempDir(), "audit.log")}
+	at := time.Date(2025, 6, 2, 14, 30, 0, 0, time.UTC)
+	entries := []Entry{
+		{Time: at, Actor: "Ada Lovelace (29682885)", ResourceID: 29682885, SelfReported: true, RemoteAddr: "10.0.0.7", Action: "claim", TicketID: 42},
+		{Actor: "resource 29682886", ResourceID: 29682886, Action: "note", TicketID: 43, Detail: "Called user", Error: "error response from API: 500"},
+	}
+	for _, e := range entries {
+		if err := l.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(l.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one line per entry, got %q", data)
+	}
+	want := `{"time":"2025-06-02T14:30:00Z","actor":"Ada Lovelace (29682885)","resourceID":29682885,"selfReported":true,` +
+		`"remoteAddr":"10.0.0.7","action":"claim","ticketID":42}`
+	if lines[0] != want {
+		t.Errorf("unexpected entry\n got  %s\n want %s", lines[0], want)
+	}
+
+	var second map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatal(err)
+	}
+	if second["detail"] != "Called user" || second["error"] != "error response from API: 500" {
+		t.Errorf("unexpected entry %v", second)
+	}
+	if _, ok := second["selfReported"]; ok {
+		t.Error("unset fields should be omitted")
+	}
+	if stamped, err := time.Parse(time.RFC3339Nano, second["time"].(string)); err != nil || time.Since(stamped) > time.Minute {
+		t.Errorf("expected entries without a time to be stamped now, got %v", second["time"])
+	}
+
+	info, err := os.Stat(l.FilePath)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected the log to be readable by its owner only, got %v", info.Mode())
+	}
+}
+
+func TestRecordConcurrent(t *testing.T) {
+	l := &Log{FilePath: filepath.Join(t.TempDir(), "audit.log")}
+	done := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		go func(i int) {
+			l.Record(Entry{Actor: "tester", Action: "note", TicketID: int64(i), Detail: strings.Repeat("x", 4096)})
+			done <- struct{}{}
+		}(i)
+	}
+	for i := 0; i < 20; i++ {
+		<-done
+	}
+
+	f, err := os.Open(l.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	count := 0
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("interleaved entry %q: %v", scanner.Text(), err)
+		}
+		count++
+	}
+	if count != 20 {
+		t.Errorf("expected 20 entries, got %d", count)
+	}
+}
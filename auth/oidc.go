@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// upper bound on a request to the identity provider
+	oidcRequestTimeout = 10 * time.Second
+	// time allowed between starting a login and the provider redirecting back
+	pendingLoginTtl = 10 * time.Minute
+	// logins waiting for the provider, guards against a flood of abandoned logins
+	maxPendingLogins = 1000
+	// tolerated difference between our clock and the provider's for the iat claim
+	clockSkew = time.Minute
+)
+
+var (
+	ErrUnknownLoginState = errors.New("unknown or expired login, please sign in again")
+	ErrUnmappedUser      = errors.New("none of the user's groups is allowed to sign in")
+)
+
+// algorithms ID tokens may be signed with. Only asymmetric ones, so a token can't be signed
+// with "none" or with the provider's public key as an HMAC secret
+var signingAlgs = []string{
+	oidc.RS256, oidc.RS384, oidc.RS512,
+	oidc.PS256, oidc.PS384, oidc.PS512,
+	oidc.ES256, oidc.ES384, oidc.ES512,
+}
+
+// OpenID Connect client settings
+type OIDCConfig struct {
+	// issuer url exactly as the provider states it (some end in "/");
+	// discovery is read from Issuer + "/.well-known/openid-configuration"
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// this server's callback, registered with the provider (e.g. https://tickets.example.com/login/oidc/callback)
+	RedirectURL string
+	// requested scopes, default openid profile email
+	Scopes []string
+	// ID token claim listing the user's groups, default groups
+	GroupsClaim string
+	// role of each group; "*" gives a role to every user of the provider. The highest matching role wins
+	GroupRoles map[string]Role
+}
+
+// prefix of single sign-on usernames. Local usernames can't contain ':', so the two never collide
+const OIDCUsernamePrefix = "oidc:"
+
+// a user signed in through the provider
+type OIDCIdentity struct {
+	Subject string
+	// OIDCUsernamePrefix + Subject, the only claim the provider guarantees is unique and stable
+	Username string
+	// preferred_username, email or sub, for display only
+	DisplayName string
+	// the email claim, only if the provider marks it verified
+	Email  string
+	Groups []string
+	Role   Role
+}
+
+// OpenID Connect authorization code client with PKCE. Discovery, signing keys and ID token checks are
+// left to go-oidc; this keeps the logins waiting for the provider to redirect back
+type OIDC struct {
+	sync.Mutex
+	config   OIDCConfig
+	client   *http.Client
+	provider *oidc.Provider
+	pending  map[string]pendingLogin
+	now      func() time.Time
+}
+
+// a login waiting for the provider, keyed by its state
+type pendingLogin struct {
+	verifier string
+	nonce    string
+	next     string
+	expires  time.Time
+}
+
+// returns a client for the provider in config
+func NewOIDC(config OIDCConfig) (*OIDC, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("oidc needs an issuer, client id and redirect url")
+	}
+	for _, endpoint := range []string{config.Issuer, config.RedirectURL} {
+		if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("oidc: %q is not an absolute http(s) url", endpoint)
+		}
+	}
+	if len(config.GroupRoles) == 0 {
+		return nil, errors.New("oidc needs at least one group to role mapping")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+	if !slices.Contains(config.Scopes, "openid") {
+		config.Scopes = append([]string{"openid"}, config.Scopes...)
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	return &OIDC{
+		config:  config,
+		client:  &http.Client{Timeout: oidcRequestTimeout},
+		pending: make(map[string]pendingLogin),
+		now:     time.Now,
+	}, nil
+}
+
+// parses group to role mappings: comma separated group=role pairs, e.g. "helpdesk=standard,it-admins=admin"
+func ParseGroupRoles(s string) (map[string]Role, error) {
+	groupRoles := make(map[string]Role)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		group, roleName, ok := strings.Cut(pair, "=")
+		group = strings.TrimSpace(group)
+		if !ok || group == "" {
+			return nil, fmt.Errorf("invalid group mapping %q, expected group=role", pair)
+		}
+		role, err := ParseRole(strings.TrimSpace(roleName))
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %w", group, err)
+		}
+		groupRoles[group] = role
+	}
+	return groupRoles, nil
+}
+
+// starts a login: returns the provider url to send the browser to, and the login's state,
+// which the browser must present again at the callback. next is kept for after the login
+func (o *OIDC) AuthCodeURL(ctx context.Context, next string) (string, string, error) {
+	provider, err := o.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	state, err := randomToken(tokenLength)
+	if err != nil {
+		return "", "", err
+	}
+	login := pendingLogin{verifier: oauth2.GenerateVerifier(), next: next, expires: o.now().Add(pendingLoginTtl)}
+	if login.nonce, err = randomToken(tokenLength); err != nil {
+		return "", "", err
+	}
+
+	o.Lock()
+	now := o.now()
+	for s, p := range o.pending {
+		if now.After(p.expires) {
+			delete(o.pending, s)
+		}
+	}
+	if len(o.pending) >= maxPendingLogins {
+		o.Unlock()
+		return "", "", errors.New("too many logins in progress, try again later")
+	}
+	o.pending[state] = login
+	o.Unlock()
+
+	authUrl := o.oauth2Config(provider).AuthCodeURL(state, oidc.Nonce(login.nonce), oauth2.S256ChallengeOption(login.verifier))
+	return authUrl, state, nil
+}
+
+// completes the login started with state: exchanges code for tokens, validates the ID token,
+// and maps the user's groups to a role. Returns the identity and the login's next
+func (o *OIDC) Exchange(ctx context.Context, state, code string) (OIDCIdentity, string, error) {
+	o.Lock()
+	login, ok := o.pending[state]
+	delete(o.pending, state)
+	o.Unlock()
+	if !ok || o.now().After(login.expires) {
+		return OIDCIdentity{}, "", ErrUnknownLoginState
+	}
+
+	provider, err := o.discover(ctx)
+	if err != nil {
+		return OIDCIdentity{}, "", err
+	}
+	token, err := o.oauth2Config(provider).Exchange(oidc.ClientContext(ctx, o.client), code, oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		return OIDCIdentity{}, "", fmt.Errorf("error redeeming code: %w", err)
+	}
+	rawIdToken, _ := token.Extra("id_token").(string)
+	if rawIdToken == "" {
+		return OIDCIdentity{}, "", errors.New("token response has no id_token")
+	}
+	claims, err := o.verifyIdToken(ctx, provider, rawIdToken, login.nonce)
+	if err != nil {
+		return OIDCIdentity{}, "", fmt.Errorf("invalid id token: %w", err)
+	}
+	identity, err := o.identity(claims)
+	return identity, login.next, err
+}
+
+// returns the provider from its discovery document, fetched on first use. Its signing keys are
+// fetched by go-oidc when needed, and again when a token names an unknown key
+func (o *OIDC) discover(ctx context.Context) (*oidc.Provider, error) {
+	o.Lock()
+	cached := o.provider
+	o.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, o.client), o.config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching oidc discovery: %w", err)
+	}
+	o.Lock()
+	o.provider = provider
+	o.Unlock()
+	return provider, nil
+}
+
+// the authorization code flow settings for provider
+func (o *OIDC) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     o.config.ClientID,
+		ClientSecret: o.config.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  o.config.RedirectURL,
+		Scopes:       o.config.Scopes,
+	}
+}
+
+// checks the ID token's signature, issuer, audience, expiry and not-before through go-oidc,
+// then its authorized party, issue time, nonce and subject. Returns its claims
+func (o *OIDC) verifyIdToken(ctx context.Context, provider *oidc.Provider, raw, nonce string) (map[string]any, error) {
+	verifier := provider.Verifier(&oidc.Config{ClientID: o.config.ClientID, SupportedSigningAlgs: signingAlgs, Now: o.now})
+	idToken, err := verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+
+	azp, hasAzp := claims["azp"].(string)
+	if (len(idToken.Audience) > 1 || hasAzp) && azp != o.config.ClientID {
+		return nil, errors.New("token was issued to another client")
+	}
+	if idToken.IssuedAt.After(o.now().Add(clockSkew)) {
+		return nil, errors.New("token was issued in the future")
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("nonce does not match the login")
+	}
+	if idToken.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return claims, nil
+}
+
+// maps verified claims to a user: the username is the subject, the display name preferred_username, email
+// or sub, the role the highest of the user's groups
+func (o *OIDC) identity(claims map[string]any) (OIDCIdentity, error) {
+	identity := OIDCIdentity{Subject: claims["sub"].(string)}
+	identity.Username = OIDCUsernamePrefix + identity.Subject
+	for _, claim := range []string{"preferred_username", "email", "sub"} {
+		if name, _ := claims[claim].(string); strings.TrimSpace(name) != "" {
+			identity.DisplayName = strings.TrimSpace(name)
+			break
+		}
+	}
+	if verified, _ := claims["email_verified"].(bool); verified {
+		identity.Email, _ = claims["email"].(string)
+	}
+
+	switch groups := claims[o.config.GroupsClaim].(type) {
+	case string:
+		identity.Groups = []string{groups}
+	case []any:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, s)
+			}
+		}
+	}
+	for _, group := range append([]string{"*"}, identity.Groups...) {
+		if role, ok := o.config.GroupRoles[group]; ok && role.rank() > identity.Role.rank() {
+			identity.Role = role
+		}
+	}
+	if identity.Role == "" {
+		return identity, fmt.Errorf("%s: %w", identity.DisplayName, ErrUnmappedUser)
+	}
+	return identity, nil
+}
+
+// orders roles by access, for picking the highest of a user's groups
+func (r Role) rank() int {
+	return map[Role]int{RoleStandard: 1, RolePrivileged: 2, RoleAdmin: 3}[r]
+}
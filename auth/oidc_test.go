@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"AutoTickets/auth/oidctest"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// a client of provider p, mapping groups with roles
+func newTestOIDC(t *testing.T, p *oidctest.Provider, roles string) *OIDC {
+	t.Helper()
+	groupRoles, err := ParseGroupRoles(roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := NewOIDC(OIDCConfig{
+		Issuer:       p.Issuer(),
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  "http://tickets.test/login/oidc/callback",
+		GroupRoles:   groupRoles,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// runs a login through the provider, returns the identity, next and error of the exchange
+func oidcLogin(t *testing.T, o *OIDC, next string) (OIDCIdentity, string, error) {
+	t.Helper()
+	ctx := context.Background()
+	authUrl, state, err := o.AuthCodeURL(ctx, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(authUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: unexpected %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if callback.Query().Get("state") != state {
+		t.Fatalf("provider returned state %q, expected %q", callback.Query().Get("state"), state)
+	}
+	return o.Exchange(ctx, state, callback.Query().Get("code"))
+}
+
+func TestOIDCLogin(t *testing.T) {
+	p := oidctest.NewProvider(t, "tickets", "s3cret&=")
+	p.SetClaims(map[string]any{"preferred_username": "Alice", "groups": []string{"helpdesk", "it-admins"}})
+	o := newTestOIDC(t, p, "helpdesk=standard,it-admins=admin")
+
+	identity, next, err := oidcLogin(t, o, "/api/v1/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "oidc:subject-1" || identity.DisplayName != "Alice" || identity.Role != RoleAdmin || identity.Subject != "subject-1" || next != "/api/v1/status" {
+		t.Errorf("unexpected identity %+v, next %q", identity, next)
+	}
+}
+
+func TestOIDCRejectsInvalidTokens(t *testing.T) {
+	tests := map[string]func(claims map[string]any){
+		"wrong audience":        func(c map[string]any) { c["aud"] = "other" },
+		"several audiences":     func(c map[string]any) { c["aud"] = []string{"tickets", "other"} },
+		"other authorized part": func(c map[string]any) { c["azp"] = "other" },
+		"wrong issuer":          func(c map[string]any) { c["iss"] = "https://evil.example" },
+		"issuer with a slash":   func(c map[string]any) { c["iss"] = c["iss"].(string) + "/" },
+		"expired":               func(c map[string]any) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"no expiry":             func(c map[string]any) { delete(c, "exp") },
+		"not yet valid":         func(c map[string]any) { c["nbf"] = time.Now().Add(time.Hour).Unix() },
+		"issued in the future":  func(c map[string]any) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+		"wrong nonce":           func(c map[string]any) { c["nonce"] = "replayed" },
+		"no subject":            func(c map[string]any) { delete(c, "sub") },
+	}
+	p := oidctest.NewProvider(t, "tickets", "secret")
+	p.SetClaims(map[string]any{"groups": []string{"helpdesk"}})
+	o := newTestOIDC(t, p, "helpdesk=standard")
+	for name, mutate := range tests {
+		p.Mutate(mutate)
+		if _, _, err := oidcLogin(t, o, "/"); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	// small differences between the provider's clock and ours are tolerated
+	accepted := map[string]func(claims map[string]any){
+		"several audiences with our azp": func(c map[string]any) { c["aud"] = []string{"tickets", "other"}; c["azp"] = "tickets" },
+		"issued just ahead of our clock": func(c map[string]any) { c["iat"] = time.Now().Add(clockSkew / 2).Unix() },
+		"valid from just ahead":          func(c map[string]any) { c["nbf"] = time.Now().Add(clockSkew / 2).Unix() },
+	}
+	for name, mutate := range accepted {
+		p.Mutate(mutate)
+		if _, _, err := oidcLogin(t, o, "/"); err != nil {
+			t.Errorf("%s: should be accepted: %v", name, err)
+		}
+	}
+}
+
+func TestOIDCRejectsForgedSignatures(t *testing.T) {
+	tests := map[string]func(header map[string]string){
+		"unsigned":                   func(h map[string]string) { h["alg"] = "none" },
+		"public key as hmac secret":  func(h map[string]string) { h["alg"] = "HS256" },
+		"algorithm not matching key": func(h map[string]string) { h["alg"] = "ES256" },
+		"unknown key id":             func(h map[string]string) { h["kid"] = "key-0" },
+	}
+	p := oidctest.NewProvider(t, "tickets", "secret")
+	p.SetClaims(map[string]any{"groups": []string{"helpdesk"}})
+	o := newTestOIDC(t, p, "helpdesk=standard")
+	for name, mutate := range tests {
+		p.MutateHeader(mutate)
+		if _, _, err := oidcLogin(t, o, "/"); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	p.MutateHeader(nil)
+	if _, _, err := oidcLogin(t, o, "/"); err != nil {
+		t.Errorf("expected a genuine token to be accepted: %v", err)
+	}
+}
+
+func TestOIDCGroupRoles(t *testing.T) {
+	p := oidctest.NewProvider(t, "tickets", "secret")
+	p.SetClaims(map[string]any{"email": "bob@example.com", "email_verified": true, "groups": "contractors"})
+
+	if _, _, err := oidcLogin(t, newTestOIDC(t, p, "helpdesk=standard"), "/"); !errors.Is(err, ErrUnmappedUser) {
+		t.Errorf("expected unmapped user, got %v", err)
+	}
+	identity, _, err := oidcLogin(t, newTestOIDC(t, p, "*=standard,contractors=privileged"), "/")
+	if err != nil || identity.Role != RolePrivileged || identity.DisplayName != "bob@example.com" || identity.Email != "bob@example.com" {
+		t.Errorf("expected privileged bob, got %+v %v", identity, err)
+	}
+
+	// an unverified email, or one without email_verified, isn't trusted to match a resource
+	for _, verified := range []any{false, nil, "true"} {
+		claims := map[string]any{"email": "bob@example.com", "groups": "contractors"}
+		if verified != nil {
+			claims["email_verified"] = verified
+		}
+		p.SetClaims(claims)
+		identity, _, err = oidcLogin(t, newTestOIDC(t, p, "contractors=standard"), "/")
+		if err != nil || identity.Email != "" || identity.DisplayName != "bob@example.com" {
+			t.Errorf("email_verified %v: expected no email, got %+v %v", verified, identity, err)
+		}
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	p := oidctest.NewProvider(t, "tickets", "secret")
+	p.SetClaims(map[string]any{"groups": []string{"helpdesk"}})
+	o := newTestOIDC(t, p, "helpdesk=standard")
+	if _, _, err := oidcLogin(t, o, "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a token naming an unknown key makes the client fetch the provider's keys again
+	if err := p.RotateKey(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := oidcLogin(t, o, "/"); err != nil {
+		t.Errorf("expected rotated key to be fetched: %v", err)
+	}
+}
+
+func TestOIDCStateAndClient(t *testing.T) {
+	p := oidctest.NewProvider(t, "tickets", "secret")
+	p.SetClaims(map[string]any{"groups": []string{"helpdesk"}})
+	o := newTestOIDC(t, p, "helpdesk=standard")
+
+	if _, _, err := o.Exchange(context.Background(), "forged", "code"); !errors.Is(err, ErrUnknownLoginState) {
+		t.Errorf("expected unknown state, got %v", err)
+	}
+
+	o.config.ClientSecret = "wrong"
+	if _, _, err := oidcLogin(t, o, "/"); err == nil {
+		t.Error("expected the provider to refuse a wrong client secret")
+	}
+}
+
+func TestParseGroupRoles(t *testing.T) {
+	roles, err := ParseGroupRoles(" helpdesk = standard , it-admins=admin,")
+	if err != nil || len(roles) != 2 || roles["helpdesk"] != RoleStandard || roles["it-admins"] != RoleAdmin {
+		t.Errorf("unexpected roles %v %v", roles, err)
+	}
+	for _, invalid := range []string{"helpdesk", "=admin", "helpdesk=root"} {
+		if _, err := ParseGroupRoles(invalid); err == nil {
+			t.Errorf("%q accepted", invalid)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// Package oidctest provides a mock OpenID Connect provider for tests: discovery, an authorization
+// endpoint that signs in a configurable user without a login page, a token endpoint checking PKCE
+// and client credentials, and the JWKS of its RSA signing key
+package oidctest
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a mock provider, serving on an httptest server
+type Provider struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	mu sync.Mutex
+	// claims added to the ID tokens issued, e.g. preferred_username and groups
+	claims map[string]any
+	// applied to each ID token's claims before signing, lets tests forge bad tokens
+	mutate func(claims map[string]any)
+	// applied to each ID token's header before signing; alg "none" leaves the token unsigned,
+	// "HS256" signs it with the public key as the HMAC secret
+	mutateHeader func(header map[string]string)
+	key          *rsa.PrivateKey
+	keyID        string
+	keys         int
+	codes        map[string]authRequest
+}
+
+// an authorization request, remembered until its code is redeemed
+type authRequest struct {
+	redirectUri   string
+	nonce         string
+	codeChallenge string
+}
+
+// starts a provider for the client clientID / clientSecret, closed when the test ends
+func NewProvider(t testing.TB, clientID, clientSecret string) *Provider {
+	t.Helper()
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		claims:       map[string]any{},
+		codes:        make(map[string]authRequest),
+	}
+	if err := p.RotateKey(); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.handleDiscovery)
+	mux.HandleFunc("GET /authorize", p.handleAuthorize)
+	mux.HandleFunc("POST /token", p.handleToken)
+	mux.HandleFunc("GET /jwks", p.handleJwks)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Server.Close)
+	return p
+}
+
+// the provider's issuer url
+func (p *Provider) Issuer() string {
+	return p.URL
+}
+
+// sets claims added to the ID tokens issued from now on
+func (p *Provider) SetClaims(claims map[string]any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.claims = claims
+}
+
+// sets a function applied to ID token claims before they are signed
+func (p *Provider) Mutate(mutate func(claims map[string]any)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mutate = mutate
+}
+
+// sets a function applied to ID token headers before they are signed
+func (p *Provider) MutateHeader(mutate func(header map[string]string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mutateHeader = mutate
+}
+
+// replaces the signing key with a new one, with a new key id. Only the new key is published
+func (p *Provider) RotateKey() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys++
+	p.key = key
+	p.keyID = "key-" + strconv.Itoa(p.keys)
+	return nil
+}
+
+// serves the discovery document
+func (p *Provider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, map[string]any{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// signs the user in at once, redirecting back to the client with a code
+func (p *Provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectUri, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("client_id") != p.ClientID || q.Get("response_type") != "code" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = authRequest{redirectUri: q.Get("redirect_uri"), nonce: q.Get("nonce"), codeChallenge: q.Get("code_challenge")}
+	p.mu.Unlock()
+
+	callback := redirectUri.Query()
+	callback.Set("code", code)
+	callback.Set("state", q.Get("state"))
+	redirectUri.RawQuery = callback.Encode()
+	http.Redirect(w, r, redirectUri.String(), http.StatusFound)
+}
+
+// redeems a code for an ID token, checking the client's credentials and PKCE verifier
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.ClientSecret)) != 1 {
+		writeJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	request, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("redirect_uri") != request.redirectUri ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != request.codeChallenge {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := p.signIdToken(request.nonce)
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// returns an ID token for the configured claims, RS256 signed unless the header is mutated
+func (p *Provider) signIdToken(nonce string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	claims := map[string]any{
+		"iss":   p.URL,
+		"sub":   "subject-1",
+		"aud":   p.ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": nonce,
+	}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+	if p.mutate != nil {
+		p.mutate(claims)
+	}
+
+	header := map[string]string{"alg": "RS256", "typ": "JWT", "kid": p.keyID}
+	if p.mutateHeader != nil {
+		p.mutateHeader(header)
+	}
+	encodedHeader, _ := json.Marshal(header)
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(encodedHeader) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch header["alg"] {
+	case "none":
+	case "HS256":
+		mac := hmac.New(sha256.New, x509.MarshalPKCS1PublicKey(&p.key.PublicKey))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	default:
+		digest := sha256.Sum256([]byte(signed))
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// serves the public signing key
+func (p *Provider) handleJwks(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	writeJson(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": p.keyID,
+		"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+func writeJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// returns a random base64url string
+func randomString() string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// bytes of randomness in a session token, login state, nonce or PKCE verifier
+const tokenLength = 32
+
+// a signed in user
+type Session struct {
+	// identifies the session without revealing its token
+	ID       string
+	Username string
+	// shown instead of Username, e.g. the single sign-on user's preferred_username
+	DisplayName string
+	// verified email of a single sign-on user, matched to an Autotask resource
+	Email   string
+	Role    Role
+	Expires time.Time
+}
+
+// sessions keyed by the hash of their token, so the map never holds a usable token
+type Sessions struct {
+	sync.Mutex
+	ttl      time.Duration
+	sessions map[string]Session
+}
+
+// returns an empty session store whose sessions last ttl
+func NewSessions(ttl time.Duration) *Sessions {
+	return &Sessions{ttl: ttl, sessions: make(map[string]Session)}
+}
+
+// starts a session for the user of session, setting its ID and expiry. Returns its token (for the cookie)
+// and the session
+func (s *Sessions) Create(session Session) (string, Session, error) {
+	token, err := randomToken(tokenLength)
+	if err != nil {
+		return "", Session{}, err
+	}
+	session.ID = hashToken(token)
+	session.Expires = time.Now().Add(s.ttl)
+
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for id, existing := range s.sessions {
+		if now.After(existing.Expires) {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[session.ID] = session
+	return token, session, nil
+}
+
+// returns the unexpired session for token
+func (s *Sessions) Get(token string) (Session, bool) {
+	if token == "" {
+		return Session{}, false
+	}
+	id := hashToken(token)
+	s.Lock()
+	defer s.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	if time.Now().After(session.Expires) {
+		delete(s.sessions, id)
+		return Session{}, false
+	}
+	return session, true
+}
+
+// true if the session with id exists and hasn't expired
+func (s *Sessions) Active(id string) bool {
+	s.Lock()
+	defer s.Unlock()
+	session, ok := s.sessions[id]
+	return ok && time.Now().Before(session.Expires)
+}
+
+// ends the session for token
+func (s *Sessions) Delete(token string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.sessions, hashToken(token))
+}
+
+// ends every session of username, returns how many were ended
+func (s *Sessions) DeleteUser(username string) int {
+	s.Lock()
+	defer s.Unlock()
+	ended := 0
+	for id, session := range s.sessions {
+		if session.Username == username {
+			delete(s.sessions, id)
+			ended++
+		}
+	}
+	return ended
+}
+
+// returns n random bytes, base64url encoded
+func randomToken(n int) (string, error) {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// returns the hex sha256 of token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
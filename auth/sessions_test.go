@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	s := NewSessions(time.Hour)
+	token, session, err := s.Create(Session{Username: "alice", Role: RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ID == token {
+		t.Error("session id must not be the token")
+	}
+	if got, ok := s.Get(token); !ok || got.Username != "alice" || got.Role != RoleAdmin {
+		t.Errorf("expected alice's session, got %+v %t", got, ok)
+	}
+	if _, ok := s.Get("forged"); ok {
+		t.Error("unknown token returned a session")
+	}
+
+	s.Delete(token)
+	if _, ok := s.Get(token); ok {
+		t.Error("deleted session still valid")
+	}
+
+	s.Create(Session{Username: "bob", Role: RoleStandard})
+	s.Create(Session{Username: "bob", Role: RoleStandard})
+	if ended := s.DeleteUser("bob"); ended != 2 {
+		t.Errorf("expected 2 sessions ended, got %d", ended)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	s := NewSessions(-time.Second)
+	token, _, _ := s.Create(Session{Username: "alice", Role: RoleStandard})
+	if _, ok := s.Get(token); ok {
+		t.Error("expired session still valid")
+	}
+}
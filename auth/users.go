@@ -0,0 +1,342 @@
+package auth
+
+import (
+	"AutoTickets/secrets"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	saltLength        = 16
+	nonceLength       = 12
+	minPasswordLength = 8
+	maxUsernameLength = 64
+)
+
+// password key derivations allowed at once. Each one holds 64MB, so a burst of logins can't exhaust memory
+var deriveSlots = make(chan struct{}, 2)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidUsername    = fmt.Errorf("username must be 1 to %d characters, without ':'", maxUsernameLength)
+	ErrWeakPassword       = fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	ErrUnknownUser        = errors.New("unknown user")
+	ErrInvalidResource    = errors.New("resource id must be positive, or 0 for none")
+	ErrUsersLocked        = errors.New("users are locked until the API secrets password is entered")
+	ErrWrongUsersPassword = errors.New("the users file doesn't open with this password")
+)
+
+// access level of a dashboard user
+type Role string
+
+const (
+	// sees sensitive tickets redacted
+	RoleStandard Role = "standard"
+	// sees sensitive tickets in full
+	RolePrivileged Role = "privileged"
+	// privileged, and manages users and API secrets
+	RoleAdmin Role = "admin"
+)
+
+// returns the role named s
+func ParseRole(s string) (Role, error) {
+	switch role := Role(s); role {
+	case RoleStandard, RolePrivileged, RoleAdmin:
+		return role, nil
+	}
+	return "", fmt.Errorf("unknown role %q, expected standard, privileged or admin", s)
+}
+
+// true if the role sees sensitive tickets in full
+func (r Role) SeesSensitive() bool {
+	return r == RolePrivileged || r == RoleAdmin
+}
+
+// true if the role manages users and API secrets
+func (r Role) IsAdmin() bool {
+	return r == RoleAdmin
+}
+
+// a user as stored in the users file
+type userRecord struct {
+	// argon2id hash of the password, under the user's own salt
+	Salt []byte
+	Hash []byte
+	Role Role
+	// Autotask resource the user acts as, 0 if not linked
+	ResourceID int64
+	Created    time.Time
+}
+
+// a user as listed to admins
+type User struct {
+	Username   string `json:"username"`
+	Role       Role   `json:"role"`
+	ResourceID int64  `json:"resourceID,omitempty"`
+}
+
+// dashboard users, their file path, and mutex. The file is salt || nonce || the gob encoded records sealed
+// with AES-GCM, under a key derived from the API secrets password, so usernames and roles are never stored
+// in the clear. Until that password is known there is no key: a file on disk stays locked, and new users
+// are kept in memory only
+type Users struct {
+	sync.RWMutex
+	FilePath string
+	records  map[string]userRecord
+	// key and salt the file is sealed with, nil until unlocked
+	key  []byte
+	salt []byte
+	// contents of the file while it is locked
+	sealed []byte
+}
+
+// loads users from filePath. A missing file holds no users, an existing one stays locked until Open
+func LoadUsers(filePath string) (*Users, error) {
+	u := &Users{FilePath: filePath, records: make(map[string]userRecord)}
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading users file: %w", err)
+	}
+	if len(data) < saltLength+nonceLength {
+		return nil, fmt.Errorf("error reading users file %s: file too short", filePath)
+	}
+	u.sealed = data
+	return u, nil
+}
+
+// returns username as it is stored: trimmed and lower case
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// true while the users file is waiting for the API secrets password
+func (u *Users) Locked() bool {
+	u.RLock()
+	defer u.RUnlock()
+	return u.sealed != nil
+}
+
+// decrypts a locked users file with the API secrets password. Without a file, keeps password to seal
+// the users created so far, and saves them
+func (u *Users) Open(password []byte) error {
+	u.Lock()
+	defer u.Unlock()
+	if u.sealed == nil {
+		return u.setKey(password)
+	}
+
+	salt := u.sealed[:saltLength]
+	key := deriveKey(password, salt)
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	plaintext, err := gcm.Open(nil, u.sealed[saltLength:saltLength+nonceLength], u.sealed[saltLength+nonceLength:], nil)
+	if err != nil {
+		return ErrWrongUsersPassword
+	}
+	records := make(map[string]userRecord)
+	if err := gob.NewDecoder(bytes.NewReader(plaintext)).Decode(&records); err != nil {
+		return fmt.Errorf("error decoding users file %s: %w", u.FilePath, err)
+	}
+	u.records, u.key, u.salt, u.sealed = records, key, salt, nil
+	return nil
+}
+
+// seals the users file under a new API secrets password from now on, and saves it
+func (u *Users) SetKey(password []byte) error {
+	u.Lock()
+	defer u.Unlock()
+	return u.setKey(password)
+}
+
+// sets the key from password and a new salt, then saves. Caller holds the lock
+func (u *Users) setKey(password []byte) error {
+	if u.sealed != nil {
+		return ErrUsersLocked
+	}
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	previousKey, previousSalt := u.key, u.salt
+	u.key, u.salt = deriveKey(password, salt), salt
+	if err := u.save(); err != nil {
+		u.key, u.salt = previousKey, previousSalt
+		return err
+	}
+	return nil
+}
+
+// returns the number of users
+func (u *Users) Count() int {
+	u.RLock()
+	defer u.RUnlock()
+	return len(u.records)
+}
+
+// returns every user and their role, sorted by username
+func (u *Users) List() []User {
+	u.RLock()
+	defer u.RUnlock()
+	users := make([]User, 0, len(u.records))
+	for name, record := range u.records {
+		users = append(users, User{Username: name, Role: record.Role, ResourceID: record.ResourceID})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+// returns the Autotask resource username acts as, 0 if not linked or not a local user
+func (u *Users) ResourceID(username string) int64 {
+	u.RLock()
+	defer u.RUnlock()
+	return u.records[NormalizeUsername(username)].ResourceID
+}
+
+// returns the role of username if password is theirs
+func (u *Users) Authenticate(username, password string) (Role, error) {
+	username = NormalizeUsername(username)
+	u.RLock()
+	record, ok := u.records[username]
+	locked := u.sealed != nil
+	u.RUnlock()
+	if locked {
+		return "", ErrUsersLocked
+	}
+	if !ok {
+		// derive anyway, so unknown usernames take as long as wrong passwords
+		deriveKey([]byte(password), make([]byte, saltLength))
+		return "", ErrInvalidCredentials
+	}
+	if subtle.ConstantTimeCompare(deriveKey([]byte(password), record.Salt), record.Hash) != 1 {
+		return "", ErrInvalidCredentials
+	}
+	return record.Role, nil
+}
+
+// creates username, or replaces their role, resource and, unless password is empty, their password.
+// New users need a password. Saves the users file
+func (u *Users) SetUser(username, password string, role Role, resourceID int64) error {
+	username = NormalizeUsername(username)
+	if username == "" || len(username) > maxUsernameLength || strings.Contains(username, ":") {
+		return ErrInvalidUsername
+	}
+	if password != "" && len(password) < minPasswordLength {
+		return ErrWeakPassword
+	}
+	if _, err := ParseRole(string(role)); err != nil {
+		return err
+	}
+	if resourceID < 0 {
+		return ErrInvalidResource
+	}
+
+	record := userRecord{Role: role, ResourceID: resourceID, Created: time.Now().UTC()}
+	if password != "" {
+		record.Salt = make([]byte, saltLength)
+		if _, err := rand.Read(record.Salt); err != nil {
+			return err
+		}
+		record.Hash = deriveKey([]byte(password), record.Salt)
+	}
+
+	u.Lock()
+	defer u.Unlock()
+	if u.sealed != nil {
+		return ErrUsersLocked
+	}
+	previous, existed := u.records[username]
+	if password == "" {
+		if !existed {
+			return ErrWeakPassword
+		}
+		record.Salt, record.Hash, record.Created = previous.Salt, previous.Hash, previous.Created
+	}
+	u.records[username] = record
+	if err := u.save(); err != nil {
+		if existed {
+			u.records[username] = previous
+		} else {
+			delete(u.records, username)
+		}
+		return err
+	}
+	return nil
+}
+
+// removes username and saves the users file
+func (u *Users) DeleteUser(username string) error {
+	username = NormalizeUsername(username)
+	u.Lock()
+	defer u.Unlock()
+	if u.sealed != nil {
+		return ErrUsersLocked
+	}
+	record, ok := u.records[username]
+	if !ok {
+		return ErrUnknownUser
+	}
+	delete(u.records, username)
+	if err := u.save(); err != nil {
+		u.records[username] = record
+		return err
+	}
+	return nil
+}
+
+// seals the records and writes them to a temporary file, then renames it over FilePath.
+// Without a key the records stay in memory only. Caller holds the lock
+func (u *Users) save() error {
+	if u.key == nil {
+		return nil
+	}
+	var plaintext bytes.Buffer
+	if err := gob.NewEncoder(&plaintext).Encode(u.records); err != nil {
+		return err
+	}
+	gcm, err := newGCM(u.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, nonceLength)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	output := append(append(append([]byte{}, u.salt...), nonce...), gcm.Seal(nil, nonce, plaintext.Bytes(), nil)...)
+	tmpPath := u.FilePath + ".tmp"
+	if err := os.WriteFile(tmpPath, output, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, u.FilePath)
+}
+
+// derives a password key, waiting for a free slot
+func deriveKey(password, salt []byte) []byte {
+	deriveSlots <- struct{}{}
+	defer func() { <-deriveSlots }()
+	return secrets.DeriveKey(password, salt)
+}
+
+// returns an AES-256-GCM cipher for key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestUsersRoundTrip(t *testing.T) {
+	path := t.TempDir() + "/secrets.gob.users"
+	users, err := LoadUsers(path)
+	if err != nil || users.Count() != 0 || users.Locked() {
+		t.Fatalf("missing file should load empty and unlocked, got %v %d", err, users.Count())
+	}
+	if err := users.SetUser(" Alice ", "correct horse", RoleAdmin, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetUser("bob", "battery staple", RoleStandard, 29682885); err != nil {
+		t.Fatal(err)
+	}
+	// without the secrets password, users are kept in memory only
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no users file before the key is known, got %v", err)
+	}
+	if err := users.Open([]byte("secrets password")); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadUsers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Locked() || loaded.Count() != 0 {
+		t.Fatalf("expected the users file to load locked, got %d users", loaded.Count())
+	}
+	if _, err := loaded.Authenticate("alice", "correct horse"); !errors.Is(err, ErrUsersLocked) {
+		t.Errorf("expected locked users, got %v", err)
+	}
+	if err := loaded.SetUser("carol", "correct horse", RoleStandard, 0); !errors.Is(err, ErrUsersLocked) {
+		t.Errorf("expected locked users, got %v", err)
+	}
+	if err := loaded.Open([]byte("wrong password")); !errors.Is(err, ErrWrongUsersPassword) || !loaded.Locked() {
+		t.Errorf("expected wrong password, got %v", err)
+	}
+	if err := loaded.Open([]byte("secrets password")); err != nil {
+		t.Fatal(err)
+	}
+
+	if list := loaded.List(); len(list) != 2 || list[0] != (User{Username: "alice", Role: RoleAdmin}) || list[1] != (User{Username: "bob", Role: RoleStandard, ResourceID: 29682885}) {
+		t.Fatalf("unexpected users %v", list)
+	}
+	if role, err := loaded.Authenticate("ALICE", "correct horse"); err != nil || role != RoleAdmin {
+		t.Errorf("expected admin, got %q %v", role, err)
+	}
+	if loaded.ResourceID("Bob") != 29682885 || loaded.ResourceID("alice") != 0 || loaded.ResourceID("carol") != 0 {
+		t.Errorf("unexpected resources %d %d", loaded.ResourceID("bob"), loaded.ResourceID("alice"))
+	}
+	for _, creds := range [][2]string{{"alice", "battery staple"}, {"carol", "correct horse"}} {
+		if _, err := loaded.Authenticate(creds[0], creds[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%v: expected invalid credentials, got %v", creds, err)
+		}
+	}
+
+	if err := loaded.DeleteUser("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.DeleteUser("bob"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("expected unknown user, got %v", err)
+	}
+}
+
+// an admin changes a role without knowing the user's password
+func TestSetUserKeepsPassword(t *testing.T) {
+	users, _ := LoadUsers(t.TempDir() + "/users")
+	if err := users.SetUser("bob", "", RoleStandard, 0); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("new user without password: expected weak password, got %v", err)
+	}
+	if err := users.SetUser("bob", "battery staple", RoleStandard, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetUser("bob", "", RolePrivileged, 29682885); err != nil {
+		t.Fatal(err)
+	}
+	if role, err := users.Authenticate("bob", "battery staple"); err != nil || role != RolePrivileged || users.ResourceID("bob") != 29682885 {
+		t.Errorf("expected privileged bob with his password, got %q %v", role, err)
+	}
+}
+
+func TestUsersFileSealed(t *testing.T) {
+	path := t.TempDir() + "/users"
+	users, _ := LoadUsers(path)
+	if err := users.Open([]byte("secrets password")); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetUser("alice", "correct horse", RolePrivileged, 0); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("users file mode %v, expected 0600", info.Mode().Perm())
+	}
+	for _, secret := range []string{"alice", "privileged", "correct horse"} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("users file contains %q in plaintext", secret)
+		}
+	}
+
+	// any change to the file, such as swapping records, fails to open
+	data[len(data)-1] ^= 1
+	os.WriteFile(path, data, 0600)
+	tampered, err := LoadUsers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tampered.Open([]byte("secrets password")); !errors.Is(err, ErrWrongUsersPassword) {
+		t.Errorf("expected a tampered file to fail, got %v", err)
+	}
+}
+
+// a new secrets password seals the users file from then on
+func TestUsersSetKey(t *testing.T) {
+	path := t.TempDir() + "/users"
+	users, _ := LoadUsers(path)
+	users.SetUser("alice", "correct horse", RoleAdmin, 0)
+	if err := users.Open([]byte("old password")); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetKey([]byte("new password")); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _ := LoadUsers(path)
+	if err := loaded.SetKey([]byte("other password")); !errors.Is(err, ErrUsersLocked) {
+		t.Errorf("expected a locked file to keep its key, got %v", err)
+	}
+	if err := loaded.Open([]byte("old password")); !errors.Is(err, ErrWrongUsersPassword) {
+		t.Errorf("expected the old password to fail, got %v", err)
+	}
+	if err := loaded.Open([]byte("new password")); err != nil || loaded.Count() != 1 {
+		t.Errorf("expected alice with the new password, got %v %d", err, loaded.Count())
+	}
+}
+
+func TestSetUserValidation(t *testing.T) {
+	users, _ := LoadUsers(t.TempDir() + "/users")
+	if err := users.SetUser(" ", "correct horse", RoleStandard, 0); !errors.Is(err, ErrInvalidUsername) {
+		t.Errorf("expected invalid username, got %v", err)
+	}
+	// reserved for single sign-on usernames
+	if err := users.SetUser(OIDCUsernamePrefix+"subject-1", "correct horse", RoleStandard, 0); !errors.Is(err, ErrInvalidUsername) {
+		t.Errorf("expected invalid username, got %v", err)
+	}
+	if err := users.SetUser("alice", "short", RoleStandard, 0); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("expected weak password, got %v", err)
+	}
+	if err := users.SetUser("alice", "correct horse", Role("root"), 0); err == nil {
+		t.Error("expected unknown role to be rejected")
+	}
+	if err := users.SetUser("alice", "correct horse", RoleStandard, -1); !errors.Is(err, ErrInvalidResource) {
+		t.Errorf("expected invalid resource, got %v", err)
+	}
+	if users.Count() != 0 {
+		t.Errorf("invalid users were stored")
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"AutoTickets/auth"
+	"AutoTickets/redact"
 	"AutoTickets/web"
 	"flag"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -30,6 +33,9 @@ func buildTimeVersion() string {
 func main() {
 
 	validateFlags()
+	redaction := loadRedactionPolicy()
+	users := loadUsers()
+	oidc := loadOidc()
 
 	// version is initialized from the executable build timestamp, or overridden
 	// by release ldflags when building releases.
@@ -42,6 +48,14 @@ func main() {
 		*verboseApi,
 		*apiStart,
 		*apiEnd,
+		*apiUrl,
+		*deltaPoll,
+		*resync,
+		*auditLogPath,
+		redaction,
+		users,
+		*sessionHours,
+		oidc,
 		version,
 	)
 
@@ -53,6 +67,8 @@ const defaultPollRate = 30
 const defaultPort = 8880
 const defaultApiStart = 6
 const defaultApiEnd = 18
+const defaultResync = 600
+const defaultSessionHours = 12
 
 var logHttp = flag.Bool("loghttp", false, "Enable HTTP request logging")
 var pollRate = flag.Int("pollrate", defaultPollRate, "API poll interval in seconds")
@@ -61,6 +77,19 @@ var saveFilePath = flag.String("filepath", "secrets.gob", "Relative filepath to
 var verboseApi = flag.Bool("verboseapi", false, "verbose API call info")
 var apiStart = flag.Int("apistart", defaultApiStart, "hour (24hr format) to start API calls")
 var apiEnd = flag.Int("apiend", defaultApiEnd, "hour (24hr format) to end API calls")
+var deltaPoll = flag.Bool("deltapoll", false, "Only request tickets changed since the last poll, with periodic full resyncs")
+var resync = flag.Int("resync", defaultResync, "seconds between full ticket resyncs when deltapoll is enabled")
+var auditLogPath = flag.String("auditlog", "audit.log", "Relative filepath of the ticket action audit log")
+var redactConfig = flag.String("redactconfig", "", "Relative filepath of a JSON redaction policy (default policy hides termination tickets)")
+var sessionHours = flag.Int("sessionhours", defaultSessionHours, "hours a dashboard login lasts")
+var oidcIssuer = flag.String("oidcissuer", "", "OpenID Connect issuer url, enables single sign-on (e.g. https://login.microsoftonline.com/<tenant>/v2.0)")
+var oidcClientID = flag.String("oidcclientid", "", "OpenID Connect client id")
+var oidcClientSecret = flag.String("oidcclientsecret", "", "OpenID Connect client secret (prefer the environment variable)")
+var oidcRedirectUrl = flag.String("oidcredirecturl", "", "this server's OpenID Connect callback url (e.g. https://tickets.example.com/login/oidc/callback)")
+var oidcScopes = flag.String("oidcscopes", "openid profile email", "space separated OpenID Connect scopes")
+var oidcGroupsClaim = flag.String("oidcgroupsclaim", "groups", "ID token claim listing the user's groups")
+var oidcRoles = flag.String("oidcroles", "", "comma separated group=role mappings for single sign-on users (e.g. helpdesk=standard,it-admins=admin)")
+var apiUrl = flag.String("apiurl", "", "Autotask REST base url, overrides zone discovery (e.g. https://webservices14.autotask.net/atservicesrest)")
 
 const envPrefix = "AUTOTICKETS_"
 
@@ -93,6 +122,45 @@ func validateFlags() {
 	if !setFlags["apiend"] {
 		*apiEnd = getEnvInt("API_END", *apiEnd)
 	}
+	if !setFlags["deltapoll"] {
+		*deltaPoll = getEnvBool("DELTA_POLL", *deltaPoll)
+	}
+	if !setFlags["resync"] {
+		*resync = getEnvInt("RESYNC", *resync)
+	}
+	if !setFlags["auditlog"] {
+		*auditLogPath = getEnvString("AUDIT_LOG", *auditLogPath)
+	}
+	if !setFlags["redactconfig"] {
+		*redactConfig = getEnvString("REDACT_CONFIG", *redactConfig)
+	}
+	if !setFlags["sessionhours"] {
+		*sessionHours = getEnvInt("SESSION_HOURS", *sessionHours)
+	}
+	if !setFlags["oidcissuer"] {
+		*oidcIssuer = getEnvString("OIDC_ISSUER", *oidcIssuer)
+	}
+	if !setFlags["oidcclientid"] {
+		*oidcClientID = getEnvString("OIDC_CLIENT_ID", *oidcClientID)
+	}
+	if !setFlags["oidcclientsecret"] {
+		*oidcClientSecret = getEnvString("OIDC_CLIENT_SECRET", *oidcClientSecret)
+	}
+	if !setFlags["oidcredirecturl"] {
+		*oidcRedirectUrl = getEnvString("OIDC_REDIRECT_URL", *oidcRedirectUrl)
+	}
+	if !setFlags["oidcscopes"] {
+		*oidcScopes = getEnvString("OIDC_SCOPES", *oidcScopes)
+	}
+	if !setFlags["oidcgroupsclaim"] {
+		*oidcGroupsClaim = getEnvString("OIDC_GROUPS_CLAIM", *oidcGroupsClaim)
+	}
+	if !setFlags["oidcroles"] {
+		*oidcRoles = getEnvString("OIDC_ROLES", *oidcRoles)
+	}
+	if !setFlags["apiurl"] {
+		*apiUrl = getEnvString("API_URL", *apiUrl)
+	}
 
 	if *port < 1 || *port > 65535 {
 		fmt.Printf("Invalid port %d, using default port %d\n", *port, defaultPort)
@@ -104,6 +172,16 @@ func validateFlags() {
 		*pollRate = defaultPollRate
 	}
 
+	if *resync < 60 || *resync > 86400 {
+		fmt.Printf("Invalid resync %d\n    min allowed = 60, max allowed = 86400 (1 day)\n    using default resync %d\n", *resync, defaultResync)
+		*resync = defaultResync
+	}
+
+	if *sessionHours < 1 || *sessionHours > 720 {
+		fmt.Printf("Invalid sessionhours %d\n    min allowed = 1, max allowed = 720 (30 days)\n    using default session hours %d\n", *sessionHours, defaultSessionHours)
+		*sessionHours = defaultSessionHours
+	}
+
 	if *apiEnd <= *apiStart {
 		fmt.Printf("Invalid active hours: end not after start\n    received start:%d, end:%d.\n    using defaults (%d-%d)\n", *apiStart, *apiEnd, defaultApiStart, defaultApiEnd)
 		*apiStart = defaultApiStart
@@ -119,6 +197,58 @@ func validateFlags() {
 	}
 }
 
+// loads the redaction policy file, or the default policy if none is configured.
+// An unreadable or invalid policy stops the server rather than risk showing sensitive tickets
+func loadRedactionPolicy() *redact.Policy {
+	if *redactConfig == "" {
+		return redact.Default()
+	}
+	policy, err := redact.Load(*redactConfig)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return policy
+}
+
+// loads the dashboard users stored next to the secrets file.
+// An unreadable users file stops the server rather than start without accounts
+func loadUsers() *auth.Users {
+	users, err := auth.LoadUsers(*saveFilePath + ".users")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return users
+}
+
+// sets up single sign-on if an issuer is configured, nil otherwise.
+// Incomplete settings stop the server rather than leave users unable to sign in
+func loadOidc() *auth.OIDC {
+	if *oidcIssuer == "" {
+		return nil
+	}
+	groupRoles, err := auth.ParseGroupRoles(*oidcRoles)
+	if err != nil {
+		fmt.Println("Invalid oidcroles:", err)
+		os.Exit(1)
+	}
+	oidc, err := auth.NewOIDC(auth.OIDCConfig{
+		Issuer:       *oidcIssuer,
+		ClientID:     *oidcClientID,
+		ClientSecret: *oidcClientSecret,
+		RedirectURL:  *oidcRedirectUrl,
+		Scopes:       strings.Fields(*oidcScopes),
+		GroupsClaim:  *oidcGroupsClaim,
+		GroupRoles:   groupRoles,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return oidc
+}
+
 func getEnvString(name, defaultValue string) string {
 	if value := os.Getenv(envPrefix + name); value != "" {
 		return value
@@ -0,0 +1,225 @@
+// Package protocol defines the messages exchanged over the /wsTickets websocket.
+//
+// Every message, in both directions, is a JSON envelope:
+//
+//	{"v": 1, "type": "delta", "seq": 42, "data": {...}}
+//
+// v is the protocol version, type names the message, seq orders ticket messages
+// (snapshot / delta, 0 on other messages) and data holds the message below matching type.
+//
+// A session starts with the client sending Hello, listing the versions it speaks. The server
+// replies Welcome with the highest version both sides speak (or Error, and closes the connection),
+// then a Snapshot of the unassigned tickets and a Status. After that the server sends a Delta whenever
+// the tickets matching the client's subscription change; a client that receives a Delta whose seq is not
+// one more than the last it saw has missed one, and sends Resync to get a new Snapshot. Subscribe switches
+// to another view or filter, and is answered with a Snapshot. Claim, Note, SetStatus and Refresh are
+// answered with a Result, Ping with a Pong.
+package protocol
+
+import (
+	"AutoTickets/tickets"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// current protocol version
+const Version = 1
+
+// versions this server speaks, oldest first
+var SupportedVersions = []int{1}
+
+// message types
+const (
+	// client -> server
+	TypeHello     = "hello"
+	TypeResync    = "resync"
+	TypeClaim     = "claim"
+	TypeNote      = "note"
+	TypeSetStatus = "setStatus"
+	TypeSubscribe = "subscribe"
+	TypeRefresh   = "refresh"
+	TypePing      = "ping"
+
+	// server -> client
+	TypeWelcome  = "welcome"
+	TypeSnapshot = "snapshot"
+	TypeDelta    = "delta"
+	TypeStatus   = "status"
+	TypeResult   = "result"
+	TypeError    = "error"
+	TypePong     = "pong"
+)
+
+// wraps every message
+type Envelope struct {
+	V    int             `json:"v"`
+	Type string          `json:"type"`
+	Seq  uint64          `json:"seq"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// messages a client may send
+type ClientMessage interface {
+	Hello | Resync | Claim | Note | SetStatus | Subscribe | Refresh | Ping
+	MessageType() string
+}
+
+// messages the server may send
+type ServerMessage interface {
+	Welcome | Snapshot | Delta | Status | Result | Error | Pong
+	MessageType() string
+}
+
+// client -> server messages
+
+// opens a session, listing the protocol versions the client speaks
+type Hello struct {
+	Versions []int  `json:"versions"`
+	Client   string `json:"client,omitempty"`
+}
+
+// asks for a fresh snapshot, after a gap in sequence numbers
+type Resync struct{}
+
+// assigns a ticket to ResourceID. RoleID 0 claims under the resource's default role
+type Claim struct {
+	TicketID   int64 `json:"ticketID"`
+	ResourceID int64 `json:"resourceID"`
+	RoleID     int64 `json:"roleID"`
+}
+
+// adds a note to a ticket as ResourceID
+type Note struct {
+	TicketID    int64  `json:"ticketID"`
+	ResourceID  int64  `json:"resourceID"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// moves a ticket to Status (a status picklist value) as ResourceID
+type SetStatus struct {
+	TicketID   int64 `json:"ticketID"`
+	ResourceID int64 `json:"resourceID"`
+	Status     int   `json:"status"`
+}
+
+// switches the tickets the client receives to those matching a filter. Answered with a Snapshot
+type Subscribe tickets.Filter
+
+// asks the server to poll the API now. Answered with a Result; changes arrive as a Delta
+type Refresh struct{}
+
+// checks the connection is alive. Answered with a Pong
+type Ping struct{}
+
+// server -> client messages
+
+// accepts a Hello
+type Welcome struct {
+	Version int    `json:"version"`
+	Server  string `json:"server"`
+	Role    string `json:"role"`
+}
+
+// every ticket matching the client's subscription, as of the envelope's seq
+type Snapshot struct {
+	Filter  tickets.Filter           `json:"filter"`
+	Tickets []tickets.AutotaskTicket `json:"tickets"`
+}
+
+// changes to the tickets matching the client's subscription since seq-1
+type Delta tickets.Diff
+
+// server and API status
+type Status struct {
+	LastApiCheck     string `json:"lastApiCheck"`
+	IsActive         bool   `json:"isActive"`
+	ApiRequestCount  int    `json:"apiRequestCount"`
+	ApiRequestLimit  int    `json:"apiRequestLimit"`
+	PollIntervalSecs int    `json:"pollIntervalSecs"`
+
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	BreakerState        string `json:"breakerState"`
+}
+
+// outcome of a Claim, Note or SetStatus, sent to the client that sent it
+type Result struct {
+	Command  string   `json:"command"`
+	TicketID int64    `json:"ticketID"`
+	Ok       bool     `json:"ok"`
+	Error    string   `json:"error,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// answers a Ping. The envelope's seq is the last ticket message sent to the client
+type Pong struct{}
+
+// a message the server could not accept
+type Error struct {
+	Message           string `json:"message"`
+	SupportedVersions []int  `json:"supportedVersions,omitempty"`
+}
+
+func (Hello) MessageType() string     { return TypeHello }
+func (Resync) MessageType() string    { return TypeResync }
+func (Claim) MessageType() string     { return TypeClaim }
+func (Note) MessageType() string      { return TypeNote }
+func (SetStatus) MessageType() string { return TypeSetStatus }
+func (Subscribe) MessageType() string { return TypeSubscribe }
+func (Refresh) MessageType() string   { return TypeRefresh }
+func (Ping) MessageType() string      { return TypePing }
+func (Welcome) MessageType() string   { return TypeWelcome }
+func (Snapshot) MessageType() string  { return TypeSnapshot }
+func (Delta) MessageType() string     { return TypeDelta }
+func (Status) MessageType() string    { return TypeStatus }
+func (Result) MessageType() string    { return TypeResult }
+func (Error) MessageType() string     { return TypeError }
+func (Pong) MessageType() string      { return TypePong }
+
+// returns the highest version in offered that this server speaks, false if there is none
+func Negotiate(offered []int) (int, bool) {
+	best := 0
+	for _, v := range offered {
+		if v > best && slices.Contains(SupportedVersions, v) {
+			best = v
+		}
+	}
+	return best, best != 0
+}
+
+// wraps a server message in a version v envelope and encodes it
+func Encode[T ServerMessage](v int, seq uint64, msg T) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding %s message: %w", msg.MessageType(), err)
+	}
+	return json.Marshal(Envelope{V: v, Type: msg.MessageType(), Seq: seq, Data: data})
+}
+
+// decodes an envelope, without its data
+func Parse(b []byte) (Envelope, error) {
+	var e Envelope
+	if err := json.Unmarshal(b, &e); err != nil {
+		return e, fmt.Errorf("invalid envelope: %w", err)
+	}
+	if e.V == 0 || e.Type == "" {
+		return e, fmt.Errorf("invalid envelope: v and type are required")
+	}
+	return e, nil
+}
+
+// decodes the data of a client message, which must be of the envelope's type
+func Decode[T ClientMessage](e Envelope) (T, error) {
+	var msg T
+	if e.Type != msg.MessageType() {
+		return msg, fmt.Errorf("expected %s message, got %s", msg.MessageType(), e.Type)
+	}
+	if len(e.Data) == 0 {
+		return msg, nil
+	}
+	if err := json.Unmarshal(e.Data, &msg); err != nil {
+		return msg, fmt.Errorf("invalid %s message: %w", e.Type, err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"AutoTickets/tickets"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	if v, ok := Negotiate([]int{1}); !ok || v != 1 {
+		t.Fatalf("expected version 1, got %d %v", v, ok)
+	}
+	if v, ok := Negotiate([]int{7, 1, 3}); !ok || v != 1 {
+		t.Fatalf("unknown versions should be skipped, got %d %v", v, ok)
+	}
+	if _, ok := Negotiate([]int{2, 3}); ok {
+		t.Fatal("expected no common version")
+	}
+	if _, ok := Negotiate(nil); ok {
+		t.Fatal("expected no common version for an empty hello")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	b, err := Encode(Version, 42, Delta{
+		Added:   []tickets.AutotaskTicket{{ID: 7, Title: "VPN down"}},
+		Removed: []int64{3},
+		Updated: []tickets.AutotaskTicket{},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var e struct {
+		V    int    `json:"v"`
+		Type string `json:"type"`
+		Seq  uint64 `json:"seq"`
+		Data struct {
+			Added   []tickets.AutotaskTicket `json:"added"`
+			Removed []int64                  `json:"removed"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(b, &e); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e.V != Version || e.Type != TypeDelta || e.Seq != 42 {
+		t.Fatalf("unexpected envelope %s", b)
+	}
+	if len(e.Data.Added) != 1 || e.Data.Added[0].Title != "VPN down" || len(e.Data.Removed) != 1 {
+		t.Fatalf("unexpected data %s", b)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	e, err := Parse([]byte(`{"v":1,"type":"claim","seq":0,"data":{"ticketID":12,"resourceID":34}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	claim, err := Decode[Claim](e)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claim.TicketID != 12 || claim.ResourceID != 34 {
+		t.Fatalf("unexpected claim %+v", claim)
+	}
+
+	if _, err := Decode[Note](e); err == nil || !strings.Contains(err.Error(), "expected note") {
+		t.Fatalf("decoding as another type should fail, got %v", err)
+	}
+
+	e, err = Parse([]byte(`{"v":1,"type":"resync"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := Decode[Resync](e); err != nil {
+		t.Fatalf("messages without data should decode, got %v", err)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, raw := range []string{`[]`, `{"type":"hello"}`, `{"v":1}`, `not json`} {
+		if _, err := Parse([]byte(raw)); err == nil {
+			t.Errorf("expected error parsing %s", raw)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package redact
+
+import (
+	"AutoTickets/tickets"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ticket fields rules can search (title, description) and mask (all of maskers)
+const (
+	FieldTitle                 = "title"
+	FieldDescription           = "description"
+	FieldTicketNumber          = "ticketNumber"
+	FieldCompanyName           = "companyName"
+	FieldContactName           = "contactName"
+	FieldContactEmail          = "contactEmail"
+	FieldAssignedResourceName  = "assignedResourceName"
+	FieldAssignedResourceEmail = "assignedResourceEmail"
+)
+
+// role of a viewer, deciding whether they see sensitive tickets in full
+type Role string
+
+// viewer roles
+const (
+	RoleStandard   Role = "standard"
+	RolePrivileged Role = "privileged"
+)
+
+// true if the role sees tickets unredacted
+func (r Role) Privileged() bool {
+	return r == RolePrivileged
+}
+
+// fields searched by keywords / patterns
+var searchable = map[string]func(*tickets.AutotaskTicket) string{
+	FieldTitle:       func(t *tickets.AutotaskTicket) string { return t.Title },
+	FieldDescription: func(t *tickets.AutotaskTicket) string { return t.Description },
+}
+
+// fields that can be masked, and how to replace them
+var maskers = map[string]func(*tickets.AutotaskTicket, string){
+	FieldTitle:                 func(t *tickets.AutotaskTicket, s string) { t.Title = s },
+	FieldDescription:           func(t *tickets.AutotaskTicket, s string) { t.Description = s },
+	FieldTicketNumber:          func(t *tickets.AutotaskTicket, s string) { t.TicketNumber = s },
+	FieldCompanyName:           func(t *tickets.AutotaskTicket, s string) { t.CompanyName = s },
+	FieldContactName:           func(t *tickets.AutotaskTicket, s string) { t.ContactName = s },
+	FieldContactEmail:          func(t *tickets.AutotaskTicket, s string) { t.ContactEmail = s },
+	FieldAssignedResourceName:  func(t *tickets.AutotaskTicket, s string) { t.AssignedResourceName = s },
+	FieldAssignedResourceEmail: func(t *tickets.AutotaskTicket, s string) { t.AssignedResourceEmail = s },
+}
+
+// replacement text used when neither rule nor policy configures one for a field
+var defaultReplacements = map[string]string{
+	FieldTitle:       "Sensitive - view on web",
+	FieldDescription: "Details of this ticket can be found on autotask.net",
+}
+
+// replacement text for fields without a default replacement
+const fallbackReplacement = "Hidden"
+
+// Rule masks fields of tickets it matches. Every criterion that is set must match:
+// a keyword or the pattern found in one of MatchFields, and the ticket's queue,
+// company and issue type in the respective lists
+type Rule struct {
+	Name        string            `json:"name"`
+	Keywords    []string          `json:"keywords,omitempty"`
+	Pattern     string            `json:"pattern,omitempty"`
+	MatchFields []string          `json:"matchFields,omitempty"`
+	QueueIDs    []int             `json:"queueIDs,omitempty"`
+	CompanyIDs  []int64           `json:"companyIDs,omitempty"`
+	IssueTypes  []int             `json:"issueTypes,omitempty"`
+	Mask        []string          `json:"mask,omitempty"`
+	Replacement map[string]string `json:"replacement,omitempty"`
+
+	text *regexp.Regexp
+}
+
+// redaction rules and default replacement text per field
+type Policy struct {
+	Replacement map[string]string `json:"replacement,omitempty"`
+	Rules       []Rule            `json:"rules"`
+}
+
+// policy used when no config file is given: hides title and description of employee termination tickets
+func Default() *Policy {
+	p := &Policy{Rules: []Rule{{
+		Name:        "terminations",
+		Keywords:    []string{"termination", "terminate", "terminated", "terminating"},
+		MatchFields: []string{FieldTitle},
+	}}}
+	if err := p.compile(); err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// loads and validates a JSON policy from path
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading redaction policy: %w", err)
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("error parsing redaction policy %s: %w", path, err)
+	}
+	if err := p.compile(); err != nil {
+		return nil, fmt.Errorf("invalid redaction policy %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// validates rules, applies defaults, and compiles keywords / patterns into a single expression per rule
+func (p *Policy) compile() error {
+	for field := range p.Replacement {
+		if maskers[field] == nil {
+			return fmt.Errorf("replacement for unknown field %q", field)
+		}
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if len(r.Keywords) == 0 && r.Pattern == "" && len(r.QueueIDs) == 0 && len(r.CompanyIDs) == 0 && len(r.IssueTypes) == 0 {
+			return fmt.Errorf("%s has no criteria", r.Name)
+		}
+		if len(r.MatchFields) == 0 {
+			r.MatchFields = []string{FieldTitle, FieldDescription}
+		}
+		for _, field := range r.MatchFields {
+			if searchable[field] == nil {
+				return fmt.Errorf("%s: cannot match on field %q", r.Name, field)
+			}
+		}
+		if len(r.Mask) == 0 {
+			r.Mask = []string{FieldTitle, FieldDescription}
+		}
+		for _, field := range r.Mask {
+			if maskers[field] == nil {
+				return fmt.Errorf("%s: cannot mask unknown field %q", r.Name, field)
+			}
+		}
+		for field := range r.Replacement {
+			if maskers[field] == nil {
+				return fmt.Errorf("%s: replacement for unknown field %q", r.Name, field)
+			}
+		}
+
+		var alternatives []string
+		for _, k := range r.Keywords {
+			if k = strings.TrimSpace(k); k != "" {
+				alternatives = append(alternatives, `\b`+regexp.QuoteMeta(k)+`\b`)
+			}
+		}
+		if r.Pattern != "" {
+			alternatives = append(alternatives, "(?:"+r.Pattern+")")
+		}
+		if len(alternatives) > 0 {
+			text, err := regexp.Compile("(?i)" + strings.Join(alternatives, "|"))
+			if err != nil {
+				return fmt.Errorf("%s: invalid pattern: %w", r.Name, err)
+			}
+			r.text = text
+		}
+	}
+	return nil
+}
+
+// true if every criterion of r that is set matches t
+func (r *Rule) matches(t *tickets.AutotaskTicket) bool {
+	if len(r.QueueIDs) > 0 && !slices.Contains(r.QueueIDs, t.QueueID) {
+		return false
+	}
+	if len(r.CompanyIDs) > 0 && !slices.Contains(r.CompanyIDs, t.CompanyID) {
+		return false
+	}
+	if len(r.IssueTypes) > 0 && !slices.Contains(r.IssueTypes, t.IssueType) {
+		return false
+	}
+	if r.text == nil {
+		return true
+	}
+	for _, field := range r.MatchFields {
+		if r.text.MatchString(searchable[field](t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns replacement text for field: the rule's, then the policy's, then the default
+func (p *Policy) replacement(r *Rule, field string) string {
+	if s, ok := r.Replacement[field]; ok {
+		return s
+	}
+	if s, ok := p.Replacement[field]; ok {
+		return s
+	}
+	if s, ok := defaultReplacements[field]; ok {
+		return s
+	}
+	return fallbackReplacement
+}
+
+// masks fields of t for every rule it matches. Rules are matched against the ticket as given,
+// so a mask from one rule never hides text another rule matches on. Returns the names of matching rules
+func (p *Policy) Apply(t *tickets.AutotaskTicket) []string {
+	var matched []*Rule
+	for i := range p.Rules {
+		if p.Rules[i].matches(t) {
+			matched = append(matched, &p.Rules[i])
+		}
+	}
+	var names []string
+	for _, r := range matched {
+		names = append(names, r.Name)
+		for _, field := range r.Mask {
+			maskers[field](t, p.replacement(r, field))
+		}
+	}
+	if len(matched) > 0 {
+		t.Redacted = true
+	}
+	return names
+}
+
+// applies the policy to every ticket in ts
+func (p *Policy) ApplyAll(ts []tickets.AutotaskTicket) {
+	for i := range ts {
+		p.Apply(&ts[i])
+	}
+}
+
+// returns ts as a viewer with role may see them: unchanged for privileged roles,
+// otherwise a redacted copy. ts itself is never modified
+func (p *Policy) ForRole(role Role, ts []tickets.AutotaskTicket) []tickets.AutotaskTicket {
+	if role.Privileged() {
+		return ts
+	}
+	view := make([]tickets.AutotaskTicket, len(ts))
+	copy(view, ts)
+	p.ApplyAll(view)
+	return view
+}
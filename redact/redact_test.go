@@ -0,0 +1,197 @@
+package redact
+
+import (
+	"AutoTickets/tickets"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writes config to a temp file and loads it
+func loadConfig(t *testing.T, config string) (*Policy, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "redact.json")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	p := Default()
+	cases := []struct {
+		title    string
+		redacted bool
+	}{
+		{"Employee termination - J. Smith", true},
+		{"TERMINATE access for contractor", true},
+		{"Terminal server is down", false},
+		{"Long-term storage quota", false},
+		{"Printer offline", false},
+	}
+	for _, c := range cases {
+		ticket := tickets.AutotaskTicket{TicketNumber: "T1", Title: c.title, Description: "details"}
+		p.Apply(&ticket)
+		if ticket.Redacted != c.redacted {
+			t.Errorf("%q: expected redacted=%v", c.title, c.redacted)
+		}
+		if c.redacted && (ticket.Title != defaultReplacements[FieldTitle] || ticket.Description != defaultReplacements[FieldDescription]) {
+			t.Errorf("%q: title / description not masked: %+v", c.title, ticket)
+		}
+		if !c.redacted && ticket.Title != c.title {
+			t.Errorf("%q: title changed without a match", c.title)
+		}
+		if ticket.TicketNumber != "T1" {
+			t.Errorf("%q: ticket number should never be masked by default", c.title)
+		}
+	}
+}
+
+func TestFieldLevelMasking(t *testing.T) {
+	p, err := loadConfig(t, `{
+		"replacement": {"description": "See Autotask"},
+		"rules": [{
+			"name": "hr",
+			"keywords": ["payroll"],
+			"matchFields": ["description"],
+			"mask": ["description", "contactName"],
+			"replacement": {"contactName": "Confidential"}
+		}]
+	}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ticket := tickets.AutotaskTicket{
+		TicketNumber: "T20240101.0001",
+		Title:        "Question",
+		Description:  "Payroll is wrong for March",
+		ContactName:  "Jane Doe",
+		CompanyName:  "Acme",
+	}
+	matched := p.Apply(&ticket)
+
+	if len(matched) != 1 || matched[0] != "hr" {
+		t.Fatalf("expected hr rule to match, got %v", matched)
+	}
+	if ticket.Description != "See Autotask" {
+		t.Errorf("policy replacement not used: %q", ticket.Description)
+	}
+	if ticket.ContactName != "Confidential" {
+		t.Errorf("rule replacement not used: %q", ticket.ContactName)
+	}
+	if ticket.TicketNumber != "T20240101.0001" || ticket.Title != "Question" || ticket.CompanyName != "Acme" {
+		t.Errorf("unmasked fields changed: %+v", ticket)
+	}
+}
+
+func TestRuleCriteria(t *testing.T) {
+	p, err := loadConfig(t, `{"rules": [
+		{"name": "security queue", "queueIDs": [7]},
+		{"name": "legal at acme", "companyIDs": [42], "pattern": "lawsuit|subpoena"},
+		{"name": "incidents", "issueTypes": [3], "mask": ["title", "companyName"]}
+	]}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		ticket  tickets.AutotaskTicket
+		matched []string
+	}{
+		{"queue only", tickets.AutotaskTicket{QueueID: 7, Title: "anything"}, []string{"security queue"}},
+		{"other queue", tickets.AutotaskTicket{QueueID: 8, Title: "anything"}, nil},
+		{"company and pattern", tickets.AutotaskTicket{CompanyID: 42, Description: "Received a SUBPOENA"}, []string{"legal at acme"}},
+		{"pattern without company", tickets.AutotaskTicket{CompanyID: 1, Description: "lawsuit"}, nil},
+		{"company without pattern", tickets.AutotaskTicket{CompanyID: 42, Description: "printer"}, nil},
+		{"several rules", tickets.AutotaskTicket{QueueID: 7, IssueType: 3, CompanyName: "Acme"}, []string{"security queue", "incidents"}},
+	}
+	for _, c := range cases {
+		ticket := c.ticket
+		matched := p.Apply(&ticket)
+		if strings.Join(matched, ",") != strings.Join(c.matched, ",") {
+			t.Errorf("%s: expected %v, got %v", c.name, c.matched, matched)
+		}
+		if ticket.Redacted != (len(c.matched) > 0) {
+			t.Errorf("%s: redacted flag wrong", c.name)
+		}
+	}
+
+	incident := tickets.AutotaskTicket{IssueType: 3, Title: "Breach", CompanyName: "Acme"}
+	p.Apply(&incident)
+	if incident.CompanyName != fallbackReplacement {
+		t.Errorf("fields without a default replacement should use the fallback, got %q", incident.CompanyName)
+	}
+}
+
+func TestOverlappingRules(t *testing.T) {
+	titleRule := `{"name": "termination", "keywords": ["termination"], "matchFields": ["title"], "mask": ["title"]}`
+	contactRule := `{"name": "executive", "keywords": ["ceo"], "matchFields": ["title"], "mask": ["contactName"]}`
+	for _, rules := range []string{titleRule + "," + contactRule, contactRule + "," + titleRule} {
+		p, err := loadConfig(t, `{"rules": [`+rules+`]}`)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ticket := tickets.AutotaskTicket{Title: "CEO termination", ContactName: "Jane Doe"}
+		matched := p.Apply(&ticket)
+
+		// each rule sees the original title, whichever masks it first
+		if len(matched) != 2 {
+			t.Errorf("[%s]: expected both rules to match, got %v", rules, matched)
+		}
+		if ticket.Title != defaultReplacements[FieldTitle] || ticket.ContactName != fallbackReplacement {
+			t.Errorf("[%s]: expected title and contact masked: %+v", rules, ticket)
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	cases := map[string]string{
+		"bad json":           `{"rules": [`,
+		"no criteria":        `{"rules": [{"name": "empty"}]}`,
+		"bad pattern":        `{"rules": [{"pattern": "("}]}`,
+		"unknown mask":       `{"rules": [{"keywords": ["x"], "mask": ["secretField"]}]}`,
+		"unknown match":      `{"rules": [{"keywords": ["x"], "matchFields": ["companyName"]}]}`,
+		"unknown replace":    `{"replacement": {"nope": "x"}, "rules": []}`,
+		"rule unknown field": `{"rules": [{"keywords": ["x"], "replacement": {"nope": "x"}}]}`,
+	}
+	for name, config := range cases {
+		if _, err := loadConfig(t, config); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestForRole(t *testing.T) {
+	p := Default()
+	raw := []tickets.AutotaskTicket{
+		{ID: 1, Title: "Employee termination", Description: "details"},
+		{ID: 2, Title: "Printer offline", Description: "tray 2"},
+	}
+
+	standard := p.ForRole(RoleStandard, raw)
+	if !standard[0].Redacted || standard[0].Title == raw[0].Title || standard[0].Description == raw[0].Description {
+		t.Errorf("standard viewers should get a redacted ticket, got %+v", standard[0])
+	}
+	if standard[1] != raw[1] {
+		t.Errorf("non-sensitive tickets should be unchanged, got %+v", standard[1])
+	}
+	if raw[0].Redacted || raw[0].Title != "Employee termination" {
+		t.Errorf("raw tickets must not be modified, got %+v", raw[0])
+	}
+
+	privileged := p.ForRole(RolePrivileged, raw)
+	if privileged[0].Redacted || privileged[0].Title != "Employee termination" {
+		t.Errorf("privileged viewers should see tickets in full, got %+v", privileged[0])
+	}
+
+	if p.ForRole(Role(""), raw)[0].Title == raw[0].Title {
+		t.Error("unknown roles must be treated as standard")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/gob"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 
 	"golang.org/x/crypto/argon2"
@@ -83,7 +84,7 @@ func (sc *SecretsCollection) DecryptSecrets(password []byte, maxExpectedBytes in
 	ciphertext := encryptedData[saltLength+nonceLength:]
 
 	// Derive key
-	key := deriveKey(password, salt)
+	key := DeriveKey(password, salt)
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -127,7 +128,7 @@ func (sc *SecretsCollection) EncryptToDisk(password []byte) error {
 	if _, err := rand.Read(salt); err != nil {
 		return err
 	}
-	key := deriveKey(password, salt)
+	key := DeriveKey(password, salt)
 
 	// Create AES-GCM cipher
 	block, err := aes.NewCipher(key)
@@ -156,7 +157,30 @@ func (sc *SecretsCollection) EncryptToDisk(password []byte) error {
 	return os.WriteFile(sc.FilePath, output, 0600)
 }
 
-// deriveKey derives a key from the password and salt using Argon2id.
-func deriveKey(password, salt []byte) []byte {
+// returns path of the plaintext zone cache, stored next to the encrypted secrets file
+func (sc *SecretsCollection) zoneFilePath() string {
+	return sc.FilePath + ".zone"
+}
+
+// returns the cached API zone url, or "" if no zone has been cached
+func (sc *SecretsCollection) LoadZoneUrl() string {
+	sc.RLock()
+	defer sc.RUnlock()
+	data, err := os.ReadFile(sc.zoneFilePath())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// caches the API zone url next to sc.FilePath. Zone urls are not sensitive, so the cache is not encrypted
+func (sc *SecretsCollection) SaveZoneUrl(zoneUrl string) error {
+	sc.RLock()
+	defer sc.RUnlock()
+	return os.WriteFile(sc.zoneFilePath(), []byte(zoneUrl+"\n"), 0600)
+}
+
+// derives a 32 byte key from the password and salt using Argon2id. Also used to hash dashboard user passwords
+func DeriveKey(password, salt []byte) []byte {
 	return argon2.IDKey(password, salt, 1, 64*1024, 4, 32) // 32 bytes = 256 bits for AES-256
 }
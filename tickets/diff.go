@@ -0,0 +1,49 @@
+package tickets
+
+import "sort"
+
+// changes between two sets of tickets, matched by id. Tickets are ordered by id
+type Diff struct {
+	Added   []AutotaskTicket `json:"added"`
+	Removed []int64          `json:"removed"`
+	Updated []AutotaskTicket `json:"updated"`
+}
+
+// true if the two sets of tickets were the same
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Updated) == 0
+}
+
+// returns the tickets added to, removed from, and updated (different fingerprint) between previous and current
+func DiffTickets(previous, current []AutotaskTicket) Diff {
+	d := Diff{
+		Added:   []AutotaskTicket{},
+		Removed: []int64{},
+		Updated: []AutotaskTicket{},
+	}
+	previousById := make(map[int64]string, len(previous))
+	for _, t := range previous {
+		previousById[t.ID] = t.Fingerprint()
+	}
+	currentIds := make(map[int64]bool, len(current))
+	for _, t := range current {
+		currentIds[t.ID] = true
+		fingerprint, ok := previousById[t.ID]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, t)
+		case fingerprint != t.Fingerprint():
+			d.Updated = append(d.Updated, t)
+		}
+	}
+	for id := range previousById {
+		if !currentIds[id] {
+			d.Removed = append(d.Removed, id)
+		}
+	}
+
+	sort.Slice(d.Added, func(i, j int) bool { return d.Added[i].ID < d.Added[j].ID })
+	sort.Slice(d.Updated, func(i, j int) bool { return d.Updated[i].ID < d.Updated[j].ID })
+	sort.Slice(d.Removed, func(i, j int) bool { return d.Removed[i] < d.Removed[j] })
+	return d
+}
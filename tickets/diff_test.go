@@ -0,0 +1,59 @@
+package tickets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func ticketIds(ts []AutotaskTicket) []int64 {
+	ids := make([]int64, 0, len(ts))
+	for _, t := range ts {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+func TestDiffTickets(t *testing.T) {
+	previous := baseTickets()
+	current := []AutotaskTicket{previous[2], previous[0], {ID: 5, Title: "Locked out"}, {ID: 4, Title: "Phone broken"}}
+	current[0].Priority = 1
+
+	d := DiffTickets(previous, current)
+	if got := ticketIds(d.Added); !reflect.DeepEqual(got, []int64{4, 5}) {
+		t.Errorf("added: expected [4 5], got %v", got)
+	}
+	if !reflect.DeepEqual(d.Removed, []int64{2}) {
+		t.Errorf("removed: expected [2], got %v", d.Removed)
+	}
+	if got := ticketIds(d.Updated); !reflect.DeepEqual(got, []int64{3}) {
+		t.Errorf("updated: expected [3], got %v", got)
+	}
+	if d.Updated[0].Priority != 1 {
+		t.Errorf("updated tickets should carry the current values, got %+v", d.Updated[0])
+	}
+	if d.Empty() {
+		t.Error("diff should not be empty")
+	}
+}
+
+func TestDiffTicketsEmpty(t *testing.T) {
+	previous := baseTickets()
+	reordered := []AutotaskTicket{previous[1], previous[2], previous[0]}
+	if d := DiffTickets(previous, reordered); !d.Empty() {
+		t.Fatalf("reordering should not produce a diff, got %+v", d)
+	}
+	if d := DiffTickets(nil, nil); !d.Empty() || d.Added == nil || d.Removed == nil || d.Updated == nil {
+		t.Fatalf("empty diff should have empty, non-nil lists, got %+v", d)
+	}
+}
+
+func TestDiffTicketsFromNothing(t *testing.T) {
+	d := DiffTickets(nil, baseTickets())
+	if got := ticketIds(d.Added); !reflect.DeepEqual(got, []int64{1, 2, 3}) {
+		t.Errorf("every ticket should be added, got %v", got)
+	}
+	d = DiffTickets(baseTickets(), nil)
+	if !reflect.DeepEqual(d.Removed, []int64{1, 2, 3}) {
+		t.Errorf("every ticket should be removed, got %v", d.Removed)
+	}
+}
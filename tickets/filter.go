@@ -0,0 +1,73 @@
+package tickets
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// named views: which open tickets a filter starts from
+const (
+	ViewUnassigned = "unassigned"
+	ViewOpen       = "open"
+)
+
+// selects open tickets: a view, narrowed by any of the lists that are set
+type Filter struct {
+	View       string  `json:"view"`
+	QueueIDs   []int   `json:"queueIDs,omitempty"`
+	CompanyIDs []int64 `json:"companyIDs,omitempty"`
+	Priorities []int   `json:"priorities,omitempty"`
+}
+
+// returns an error if the view is unknown. An empty view is ViewUnassigned
+func (f Filter) Validate() error {
+	switch f.View {
+	case "", ViewUnassigned, ViewOpen:
+		return nil
+	}
+	return fmt.Errorf("unknown view %q", f.View)
+}
+
+// true if t is in the filter's view, and in every list that is set
+func (f Filter) Match(t AutotaskTicket) bool {
+	if f.View != ViewOpen && t.AssignedResourceID != "" {
+		return false
+	}
+	return (len(f.QueueIDs) == 0 || slices.Contains(f.QueueIDs, t.QueueID)) &&
+		(len(f.CompanyIDs) == 0 || slices.Contains(f.CompanyIDs, t.CompanyID)) &&
+		(len(f.Priorities) == 0 || slices.Contains(f.Priorities, t.Priority))
+}
+
+// returns the tickets in ts matching the filter
+func (f Filter) Apply(ts []AutotaskTicket) []AutotaskTicket {
+	matched := make([]AutotaskTicket, 0, len(ts))
+	for _, t := range ts {
+		if f.Match(t) {
+			matched = append(matched, t)
+		}
+	}
+	return matched
+}
+
+// returns a string identifying the filter; filters selecting the same tickets have the same key
+func (f Filter) Key() string {
+	view := f.View
+	if view == "" {
+		view = ViewUnassigned
+	}
+	return fmt.Sprintf("%s|q%s|c%s|p%s", view, joinSorted(f.QueueIDs), joinSorted(f.CompanyIDs), joinSorted(f.Priorities))
+}
+
+// sorts a copy of values, and joins them with commas
+func joinSorted[T int | int64](values []T) string {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+	parts := make([]string, len(sorted))
+	for i, v := range sorted {
+		parts[i] = strconv.FormatInt(int64(v), 10)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,47 @@
+package tickets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	ts := []AutotaskTicket{
+		{ID: 1, QueueID: 10, CompanyID: 100, Priority: 1},
+		{ID: 2, QueueID: 10, CompanyID: 200, Priority: 2, AssignedResourceID: "29682885"},
+		{ID: 3, QueueID: 20, CompanyID: 100, Priority: 2},
+	}
+	cases := []struct {
+		filter Filter
+		want   []int64
+	}{
+		{Filter{}, []int64{1, 3}},
+		{Filter{View: ViewOpen}, []int64{1, 2, 3}},
+		{Filter{View: ViewOpen, QueueIDs: []int{10}}, []int64{1, 2}},
+		{Filter{CompanyIDs: []int64{100}, Priorities: []int{2}}, []int64{3}},
+		{Filter{View: ViewOpen, CompanyIDs: []int64{200, 300}}, []int64{2}},
+		{Filter{Priorities: []int{4}}, []int64{}},
+	}
+	for _, c := range cases {
+		if got := ticketIds(c.filter.Apply(ts)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%+v: expected %v, got %v", c.filter, c.want, got)
+		}
+	}
+}
+
+func TestFilterKey(t *testing.T) {
+	a := Filter{QueueIDs: []int{2, 1, 2}, CompanyIDs: []int64{5}}
+	b := Filter{View: ViewUnassigned, QueueIDs: []int{1, 2}, CompanyIDs: []int64{5}}
+	if a.Key() != b.Key() {
+		t.Errorf("equivalent filters should share a key: %q, %q", a.Key(), b.Key())
+	}
+	if a.Key() == (Filter{View: ViewOpen, QueueIDs: []int{1, 2}, CompanyIDs: []int64{5}}).Key() {
+		t.Error("different views should have different keys")
+	}
+	if a.Key() == (Filter{QueueIDs: []int{1, 2}, Priorities: []int{5}}).Key() {
+		t.Error("values in different lists should have different keys")
+	}
+	if err := (Filter{View: "mine"}).Validate(); err == nil {
+		t.Error("expected error for unknown view")
+	}
+}
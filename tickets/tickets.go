@@ -2,18 +2,62 @@ package tickets
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"hash"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 )
 
+// Autotask status value of completed tickets
+const StatusComplete = 5
+
 // Ticket fields for use in server
 type AutotaskTicket struct {
-	ID                 int64  `json:"id"`
-	AssignedResourceID string `json:"assignedResourceID"`
-	CreateDate         string `json:"createDate"`
-	Description        string `json:"description"`
-	Title              string `json:"title"`
-	From               string `json:"from,omitempty"`
+	ID                 int64     `json:"id"`
+	TicketNumber       string    `json:"ticketNumber"`
+	AssignedResourceID string    `json:"assignedResourceID"`
+	CreateDate         time.Time `json:"createDate"`
+	Description        string    `json:"description"`
+	Title              string    `json:"title"`
+	From               string    `json:"from,omitempty"`
+	Redacted           bool      `json:"redacted,omitempty"`
+
+	// resolved from AssignedResourceID, empty until resources are loaded
+	AssignedResourceName  string `json:"assignedResourceName,omitempty"`
+	AssignedResourceEmail string `json:"assignedResourceEmail,omitempty"`
+
+	CompanyID int64 `json:"companyID"`
+	ContactID int64 `json:"contactID"`
+
+	// resolved from CompanyID / ContactID, empty until companies and contacts are loaded
+	CompanyName  string `json:"companyName,omitempty"`
+	ContactName  string `json:"contactName,omitempty"`
+	ContactEmail string `json:"contactEmail,omitempty"`
+
+	// picklist values, see entity field metadata for labels
+	Priority  int `json:"priority"`
+	Status    int `json:"status"`
+	QueueID   int `json:"queueID"`
+	IssueType int `json:"issueType"`
+	Source    int `json:"source"`
+
+	// picklist labels, empty until metadata is loaded
+	PriorityLabel  string `json:"priorityLabel,omitempty"`
+	StatusLabel    string `json:"statusLabel,omitempty"`
+	QueueLabel     string `json:"queueLabel,omitempty"`
+	IssueTypeLabel string `json:"issueTypeLabel,omitempty"`
+	SourceLabel    string `json:"sourceLabel,omitempty"`
+
+	DueDateTime      time.Time `json:"dueDateTime,omitzero"`
+	LastActivityDate time.Time `json:"lastActivityDate,omitzero"`
+
+	// SLA milestones
+	FirstResponseDueDateTime  time.Time `json:"firstResponseDueDateTime,omitzero"`
+	ResolutionPlanDueDateTime time.Time `json:"resolutionPlanDueDateTime,omitzero"`
+	ResolvedDueDateTime       time.Time `json:"resolvedDueDateTime,omitzero"`
 }
 
 // tickets, hash, and mutex
@@ -21,26 +65,95 @@ type TicketCollection struct {
 	sync.RWMutex
 	Tickets *[]AutotaskTicket `json:"tickets"`
 	Hash    string            `json:"hash"`
+
+	// closed and cleared when Hash changes, created by Watch
+	changed chan struct{}
 }
 
-// computes hash of titles, returns true if hash has changed
+// computes hash of all open tickets, returns true if hash has changed
 func (tc *TicketCollection) CheckForNewHash() bool {
-	unassignedTickets := tc.GetUnassignedTickets()
-	titles := ""
-	for _, t := range unassignedTickets {
-		titles += t.Title
-	}
-	newHash := sha256.Sum256([]byte(titles))
-	newHashStr := hex.EncodeToString(newHash[:])
+	newHashStr := HashTickets(tc.GetTickets())
 	tc.Lock()
 	defer tc.Unlock()
 	if newHashStr != tc.Hash {
 		tc.Hash = newHashStr
+		if tc.changed != nil {
+			close(tc.changed)
+			tc.changed = nil
+		}
 		return true
 	}
 	return false
 }
 
+// returns a hash of every ticket's id and fingerprint, in id order, so the hash does not depend on API order
+func HashTickets(ts []AutotaskTicket) string {
+	sorted := make([]AutotaskTicket, len(ts))
+	copy(sorted, ts)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	h := sha256.New()
+	for _, t := range sorted {
+		writeField(h, strconv.FormatInt(t.ID, 10))
+		writeField(h, t.Fingerprint())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// returns a hash of the ticket fields sent to clients. LastActivityDate is left out: it moves on
+// every note and time entry, which clients don't see
+func (t AutotaskTicket) Fingerprint() string {
+	h := sha256.New()
+	for _, field := range []string{
+		strconv.FormatInt(t.ID, 10),
+		t.TicketNumber,
+		t.AssignedResourceID,
+		t.AssignedResourceName,
+		t.AssignedResourceEmail,
+		t.Title,
+		t.Description,
+		t.From,
+		formatTime(t.CreateDate),
+		strconv.FormatInt(t.CompanyID, 10),
+		strconv.FormatInt(t.ContactID, 10),
+		t.CompanyName,
+		t.ContactName,
+		t.ContactEmail,
+		strconv.Itoa(t.Priority),
+		strconv.Itoa(t.Status),
+		strconv.Itoa(t.QueueID),
+		strconv.Itoa(t.IssueType),
+		strconv.Itoa(t.Source),
+		t.PriorityLabel,
+		t.StatusLabel,
+		t.QueueLabel,
+		t.IssueTypeLabel,
+		t.SourceLabel,
+		formatTime(t.DueDateTime),
+		formatTime(t.FirstResponseDueDateTime),
+		formatTime(t.ResolutionPlanDueDateTime),
+		formatTime(t.ResolvedDueDateTime),
+	} {
+		writeField(h, field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writes a length-prefixed field, so moving text between adjacent fields changes the hash
+func writeField(h hash.Hash, field string) {
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(field)))
+	h.Write(length[:])
+	h.Write([]byte(field))
+}
+
+// formats t for fingerprinting; the zero time is empty
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
 // returns slice of tickets with blank resourceid
 func (tc *TicketCollection) GetUnassignedTickets() []AutotaskTicket {
 	tc.RLock()
@@ -54,6 +167,15 @@ func (tc *TicketCollection) GetUnassignedTickets() []AutotaskTicket {
 	return unassignedTickets
 }
 
+// returns a copy of all tickets
+func (tc *TicketCollection) GetTickets() []AutotaskTicket {
+	tc.RLock()
+	defer tc.RUnlock()
+	allTickets := make([]AutotaskTicket, len(*tc.Tickets))
+	copy(allTickets, *tc.Tickets)
+	return allTickets
+}
+
 // returns current hash value of tickets
 func (tc *TicketCollection) GetCurrentHash() string {
 	tc.RLock()
@@ -61,9 +183,51 @@ func (tc *TicketCollection) GetCurrentHash() string {
 	return tc.Hash
 }
 
+// returns the current hash, and a channel that is closed the next time CheckForNewHash finds a new hash
+func (tc *TicketCollection) Watch() (string, <-chan struct{}) {
+	tc.Lock()
+	defer tc.Unlock()
+	if tc.changed == nil {
+		tc.changed = make(chan struct{})
+	}
+	return tc.Hash, tc.changed
+}
+
 // sets tickets slice to new value
 func (tc *TicketCollection) SetTickets(newTickets *[]AutotaskTicket) {
 	tc.Lock()
 	defer tc.Unlock()
 	tc.Tickets = newTickets
 }
+
+// merges changed tickets into the collection: new tickets are appended, known tickets
+// are replaced, and completed tickets are removed. A ticket changed more than once takes its last change
+func (tc *TicketCollection) MergeTickets(changed []AutotaskTicket) {
+	tc.Lock()
+	defer tc.Unlock()
+	changedById := make(map[int64]AutotaskTicket, len(changed))
+	for _, t := range changed {
+		changedById[t.ID] = t
+	}
+
+	merged := make([]AutotaskTicket, 0, len(*tc.Tickets)+len(changed))
+	for _, t := range *tc.Tickets {
+		if update, ok := changedById[t.ID]; ok {
+			delete(changedById, t.ID)
+			t = update
+		}
+		if t.Status != StatusComplete {
+			merged = append(merged, t)
+		}
+	}
+	// remaining changes are new tickets, appended in API order
+	for _, t := range changed {
+		if latest, ok := changedById[t.ID]; ok {
+			if latest.Status != StatusComplete {
+				merged = append(merged, latest)
+			}
+			delete(changedById, t.ID)
+		}
+	}
+	tc.Tickets = &merged
+}
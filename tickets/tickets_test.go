@@ -0,0 +1,266 @@
+package tickets
+
+import (
+	"testing"
+	"time"
+)
+
+// collection holding ts, with its hash already computed
+func newCollection(t *testing.T, ts []AutotaskTicket) *TicketCollection {
+	t.Helper()
+	tc := &TicketCollection{Tickets: &ts}
+	tc.CheckForNewHash()
+	return tc
+}
+
+func baseTickets() []AutotaskTicket {
+	created := time.Date(2025, 6, 2, 14, 30, 0, 0, time.UTC)
+	return []AutotaskTicket{
+		{ID: 1, Title: "Printer offline", Description: "tray 2", Priority: 2, Status: 1, CreateDate: created},
+		{ID: 2, Title: "VPN down", Description: "since login", Priority: 1, Status: 1, CreateDate: created},
+		{ID: 3, Title: "New laptop", Description: "for starter", Priority: 3, Status: 1, CreateDate: created},
+	}
+}
+
+func TestCheckForNewHashUnchanged(t *testing.T) {
+	tc := newCollection(t, baseTickets())
+	same := baseTickets()
+	tc.SetTickets(&same)
+	if tc.CheckForNewHash() {
+		t.Fatal("identical tickets should not change the hash")
+	}
+}
+
+func TestCheckForNewHashIgnoresOrder(t *testing.T) {
+	tc := newCollection(t, baseTickets())
+	reordered := baseTickets()
+	reordered[0], reordered[2] = reordered[2], reordered[0]
+	tc.SetTickets(&reordered)
+	if tc.CheckForNewHash() {
+		t.Fatal("API order should not change the hash")
+	}
+}
+
+func TestCheckForNewHashDetectsChanges(t *testing.T) {
+	cases := map[string]func(ts []AutotaskTicket){
+		"titles swapped": func(ts []AutotaskTicket) {
+			ts[0].Title, ts[1].Title = ts[1].Title, ts[0].Title
+		},
+		"priority changed":   func(ts []AutotaskTicket) { ts[1].Priority = 4 },
+		"status changed":     func(ts []AutotaskTicket) { ts[1].Status = 8 },
+		"description edited": func(ts []AutotaskTicket) { ts[2].Description += "." },
+		"label loaded":       func(ts []AutotaskTicket) { ts[0].PriorityLabel = "High" },
+		"company resolved":   func(ts []AutotaskTicket) { ts[0].CompanyName = "Acme" },
+		"resource email set": func(ts []AutotaskTicket) { ts[0].AssignedResourceEmail = "ada@example.com" },
+		"due date set":       func(ts []AutotaskTicket) { ts[0].DueDateTime = time.Date(2025, 6, 3, 0, 0, 0, 0, time.UTC) },
+		"text moved between fields": func(ts []AutotaskTicket) {
+			ts[0].Title, ts[0].Description = "Printer offlinet", "ray 2"
+		},
+		"ids swapped": func(ts []AutotaskTicket) {
+			ts[0].ID, ts[1].ID = ts[1].ID, ts[0].ID
+		},
+	}
+	for name, change := range cases {
+		t.Run(name, func(t *testing.T) {
+			tc := newCollection(t, baseTickets())
+			changed := baseTickets()
+			change(changed)
+			tc.SetTickets(&changed)
+			if !tc.CheckForNewHash() {
+				t.Fatal("expected hash to change")
+			}
+			if tc.CheckForNewHash() {
+				t.Fatal("hash should be stable once updated")
+			}
+		})
+	}
+}
+
+func TestCheckForNewHashAssignment(t *testing.T) {
+	tc := newCollection(t, baseTickets())
+	assigned := baseTickets()
+	assigned[1].AssignedResourceID = "29682885"
+	tc.SetTickets(&assigned)
+	if !tc.CheckForNewHash() {
+		t.Fatal("assigning a ticket should change the hash")
+	}
+
+	// assigned tickets are sent to clients viewing all open tickets
+	edited := baseTickets()
+	edited[1].AssignedResourceID = "29682885"
+	edited[1].Title = "VPN down for everyone"
+	tc.SetTickets(&edited)
+	if !tc.CheckForNewHash() {
+		t.Fatal("changes to assigned tickets should change the hash")
+	}
+
+	reassigned := baseTickets()
+	reassigned[1].AssignedResourceID = "29682886"
+	reassigned[1].Title = "VPN down for everyone"
+	tc.SetTickets(&reassigned)
+	if !tc.CheckForNewHash() {
+		t.Fatal("reassigning a ticket should change the hash")
+	}
+}
+
+func TestCheckForNewHashEmpty(t *testing.T) {
+	tc := &TicketCollection{Tickets: &[]AutotaskTicket{}}
+	if !tc.CheckForNewHash() {
+		t.Fatal("first check should always report a change")
+	}
+	if tc.CheckForNewHash() {
+		t.Fatal("empty board should have a stable hash")
+	}
+	one := baseTickets()[:1]
+	tc.SetTickets(&one)
+	if !tc.CheckForNewHash() {
+		t.Fatal("first ticket arriving should change the hash")
+	}
+}
+
+func TestFingerprintIgnoresLastActivity(t *testing.T) {
+	a := baseTickets()[0]
+	b := a
+	b.LastActivityDate = time.Now()
+	if a.Fingerprint() != b.Fingerprint() {
+		t.Fatal("lastActivityDate should not affect the fingerprint")
+	}
+	c := a
+	c.CreateDate = a.CreateDate.In(time.FixedZone("EST", -5*3600))
+	if a.Fingerprint() != c.Fingerprint() {
+		t.Fatal("the same instant in another zone should have the same fingerprint")
+	}
+}
+
+func TestWatchClosedOnNewHash(t *testing.T) {
+	tc := newCollection(t, baseTickets())
+	hash, changed := tc.Watch()
+	if hash != tc.GetCurrentHash() {
+		t.Fatal("Watch should return the current hash")
+	}
+
+	same := baseTickets()
+	tc.SetTickets(&same)
+	tc.CheckForNewHash()
+	select {
+	case <-changed:
+		t.Fatal("channel closed although the hash did not change")
+	default:
+	}
+
+	updated := baseTickets()
+	updated[0].Title = "Printer jammed"
+	tc.SetTickets(&updated)
+	tc.CheckForNewHash()
+	select {
+	case <-changed:
+	default:
+		t.Fatal("channel not closed after the hash changed")
+	}
+	if newHash, next := tc.Watch(); newHash == hash || next == changed {
+		t.Fatal("Watch should return the new hash and a fresh channel")
+	}
+}
+
+// ids and titles of the collection's tickets, in order
+func ticketTitles(tc *TicketCollection) map[int64]string {
+	titles := make(map[int64]string)
+	for _, t := range tc.GetTickets() {
+		titles[t.ID] = t.Title
+	}
+	return titles
+}
+
+func TestMergeTickets(t *testing.T) {
+	tests := []struct {
+		name    string
+		changed []AutotaskTicket
+		want    []int64
+		titles  map[int64]string
+	}{
+		{
+			name: "no changes",
+			want: []int64{1, 2, 3},
+		},
+		{
+			name:    "new tickets appended in API order",
+			changed: []AutotaskTicket{{ID: 5, Title: "five", Status: 1}, {ID: 4, Title: "four", Status: 1}},
+			want:    []int64{1, 2, 3, 5, 4},
+		},
+		{
+			name:    "changed ticket replaced in place",
+			changed: []AutotaskTicket{{ID: 2, Title: "VPN flapping", Status: 8}},
+			want:    []int64{1, 2, 3},
+			titles:  map[int64]string{2: "VPN flapping"},
+		},
+		{
+			name:    "completed ticket removed",
+			changed: []AutotaskTicket{{ID: 1, Title: "Printer offline", Status: StatusComplete}},
+			want:    []int64{2, 3},
+		},
+		{
+			name:    "ticket completed before it was seen is not added",
+			changed: []AutotaskTicket{{ID: 6, Title: "quick fix", Status: StatusComplete}},
+			want:    []int64{1, 2, 3},
+		},
+		{
+			name: "latest change of a ticket listed twice wins",
+			changed: []AutotaskTicket{
+				{ID: 7, Title: "first", Status: 1},
+				{ID: 3, Title: "first", Status: 1},
+				{ID: 7, Title: "second", Status: 1},
+				{ID: 3, Title: "second", Status: 1},
+			},
+			want:   []int64{1, 2, 3, 7},
+			titles: map[int64]string{3: "second", 7: "second"},
+		},
+		{
+			name: "mixed",
+			changed: []AutotaskTicket{
+				{ID: 3, Status: StatusComplete},
+				{ID: 1, Title: "Printer fixed?", Status: 8},
+				{ID: 8, Title: "eight", Status: 1},
+			},
+			want:   []int64{1, 2, 8},
+			titles: map[int64]string{1: "Printer fixed?"},
+		},
+	}
+	for _, tt := range tests {
+		tc := newCollection(t, baseTickets())
+		tc.MergeTickets(tt.changed)
+
+		var ids []int64
+		for _, ticket := range tc.GetTickets() {
+			ids = append(ids, ticket.ID)
+		}
+		if len(ids) != len(tt.want) {
+			t.Errorf("%s: expected ids %v, got %v", tt.name, tt.want, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.want[i] {
+				t.Errorf("%s: expected ids %v, got %v", tt.name, tt.want, ids)
+				break
+			}
+		}
+		titles := ticketTitles(tc)
+		for id, title := range tt.titles {
+			if titles[id] != title {
+				t.Errorf("%s: ticket %d: expected title %q, got %q", tt.name, id, title, titles[id])
+			}
+		}
+	}
+}
+
+func TestMergeTicketsChangesHash(t *testing.T) {
+	tc := newCollection(t, baseTickets())
+	tc.MergeTickets([]AutotaskTicket{{ID: 2, Title: "VPN down", Description: "since login", Priority: 1, Status: 1,
+		CreateDate: time.Date(2025, 6, 2, 14, 30, 0, 0, time.UTC), LastActivityDate: time.Now()}})
+	if tc.CheckForNewHash() {
+		t.Error("a change of lastActivityDate alone should keep the hash")
+	}
+	tc.MergeTickets([]AutotaskTicket{{ID: 2, Status: StatusComplete}})
+	if !tc.CheckForNewHash() {
+		t.Error("a completed ticket should change the hash")
+	}
+}
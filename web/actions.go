@@ -0,0 +1,265 @@
+package web
+
+import (
+	"AutoTickets/api"
+	"AutoTickets/audit"
+	"AutoTickets/auth"
+	"AutoTickets/tickets"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// upper bound on a single ticket write, including the re-read and retries
+const actionTimeout = time.Minute
+
+var (
+	// returned (wrapped) when a submitted action is incomplete or invalid
+	errInvalidAction = errors.New("invalid request")
+	// returned (wrapped) when the signed in user may not act as the submitted resource
+	errActorNotAllowed = errors.New("not allowed")
+)
+
+// who performed a ticket action: the signed in user, the resource they act as, and where the request came from
+type actionActor struct {
+	Session auth.Session
+	// the resource chosen on the board, until verifyActor replaces it with the user's own
+	ResourceID int64
+	// true when the resource is only what the client said, because the user isn't linked to one
+	SelfReported bool
+	RemoteAddr   string
+}
+
+// returns the actor of a request by session, acting as the submitted resource
+func newActionActor(session auth.Session, resourceID int64, remoteAddr string) actionActor {
+	return actionActor{Session: session, ResourceID: resourceID, RemoteAddr: remoteAddr}
+}
+
+// assigns ticketID to the acting resource, then polls so every client sees the change.
+// Writes from this server are serialized, so two techs claiming at once can't both pass the re-read check
+func (w *WebApp) claimTicket(actor actionActor, ticketID, roleID int64) (tickets.AutotaskTicket, error) {
+	ticket, err := w.runAction(actor, "claim", ticketID, "", func(ctx context.Context, client *api.Client) (tickets.AutotaskTicket, error) {
+		return client.ClaimTicket(ctx, ticketID, actor.ResourceID, roleID)
+	})
+	if err == nil {
+		go w.pollApi()
+	}
+	return ticket, err
+}
+
+// posts a note to ticketID
+func (w *WebApp) addTicketNote(actor actionActor, ticketID int64, title, description string) error {
+	title = strings.TrimSpace(title)
+	description = strings.TrimSpace(description)
+	if description == "" {
+		return fmt.Errorf("%w: note text is required", errInvalidAction)
+	}
+	if title == "" {
+		title = "Note from AutoTickets"
+	}
+	_, err := w.runAction(actor, "note", ticketID, title, func(ctx context.Context, client *api.Client) (tickets.AutotaskTicket, error) {
+		return tickets.AutotaskTicket{}, client.AddTicketNote(ctx, ticketID, title, description)
+	})
+	return err
+}
+
+// moves ticketID to status, which must be a known status picklist value, then polls so every client sees the change
+func (w *WebApp) setTicketStatus(actor actionActor, ticketID int64, status int) (tickets.AutotaskTicket, error) {
+	label := w.metadata.Label("status", status)
+	if label == "" {
+		return tickets.AutotaskTicket{}, fmt.Errorf("%w: unknown status %d", errInvalidAction, status)
+	}
+	ticket, err := w.runAction(actor, "setStatus", ticketID, label, func(ctx context.Context, client *api.Client) (tickets.AutotaskTicket, error) {
+		return client.SetTicketStatus(ctx, ticketID, status)
+	})
+	if err == nil {
+		go w.pollApi()
+	}
+	return ticket, err
+}
+
+// validates t against the ticket picklists and creates it, then polls so every client sees it
+func (w *WebApp) createTicket(actor actionActor, t api.NewTicket) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), actionTimeout)
+	defer cancel()
+	client, err := w.getApiClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if actor, err = w.verifyActor(actor); err != nil {
+		return 0, err
+	}
+	if err := w.metadata.Refresh(ctx, client); err != nil {
+		return 0, err
+	}
+	if err := w.validateNewTicket(&t); err != nil {
+		return 0, err
+	}
+
+	ticketID, err := client.CreateTicket(ctx, t)
+	w.auditAction(ctx, client, actor, "create", ticketID, t.Title, err)
+	if err != nil {
+		return 0, err
+	}
+	go w.pollApi()
+	return ticketID, nil
+}
+
+// checks required fields of a new ticket, and that picklist fields hold known values
+func (w *WebApp) validateNewTicket(t *api.NewTicket) error {
+	t.Title = strings.TrimSpace(t.Title)
+	var problems []string
+	if t.CompanyID <= 0 {
+		problems = append(problems, "company is required")
+	}
+	if t.Title == "" {
+		problems = append(problems, "title is required")
+	}
+	required := []struct {
+		field string
+		value int
+	}{{"queueID", t.QueueID}, {"priority", t.Priority}, {"status", t.Status}}
+	for _, r := range required {
+		if w.metadata.Label(r.field, r.value) == "" {
+			problems = append(problems, fmt.Sprintf("%s %d is not a valid choice", r.field, r.value))
+		}
+	}
+	optional := []struct {
+		field string
+		value int
+	}{{"issueType", t.IssueType}, {"source", t.Source}}
+	for _, o := range optional {
+		if o.value != 0 && w.metadata.Label(o.field, o.value) == "" {
+			problems = append(problems, fmt.Sprintf("%s %d is not a valid choice", o.field, o.value))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", errInvalidAction, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// validates actor and ticket, runs write against the api client and audits the outcome
+func (w *WebApp) runAction(
+	actor actionActor,
+	action string,
+	ticketID int64,
+	detail string,
+	write func(context.Context, *api.Client) (tickets.AutotaskTicket, error)) (tickets.AutotaskTicket, error) {
+
+	if ticketID <= 0 {
+		return tickets.AutotaskTicket{}, fmt.Errorf("%w: ticket is required", errInvalidAction)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), actionTimeout)
+	defer cancel()
+	client, err := w.getApiClient(ctx)
+	if err != nil {
+		return tickets.AutotaskTicket{}, err
+	}
+	if actor, err = w.verifyActor(actor); err != nil {
+		return tickets.AutotaskTicket{}, err
+	}
+
+	w.ticketWrites.Lock()
+	ticket, err := write(ctx, client)
+	w.ticketWrites.Unlock()
+
+	w.auditAction(ctx, client, actor, action, ticketID, detail, err)
+	if err == nil && w.serverParams.verboseApi {
+		timeStamp := time.Now().Format("15:04 Jan 2")
+		fmt.Printf("\n[%v] %s on ticket %d by %s as resource %d\n", timeStamp, action, ticketID, actor.Session.DisplayName, actor.ResourceID)
+	}
+	return ticket, err
+}
+
+// ties actor to the resource of the signed in user: local users are linked by an admin, single sign-on
+// users by their verified email, through the index of active resources the poller keeps. Users linked
+// to a resource can only act as it (submitting 0 picks it); admins who aren't linked may act as the
+// resource they chose, which is audited as self-reported
+func (w *WebApp) verifyActor(actor actionActor) (actionActor, error) {
+	own := w.users.ResourceID(actor.Session.Username)
+	if own == 0 && actor.Session.Email != "" {
+		own, _ = w.resources.ActiveByEmail(actor.Session.Email)
+	}
+	switch {
+	case own != 0 && actor.ResourceID != 0 && actor.ResourceID != own:
+		return actor, fmt.Errorf("%w: you can only act as your own resource %d", errActorNotAllowed, own)
+	case own != 0:
+		actor.ResourceID = own
+	case !actor.Session.Role.IsAdmin():
+		return actor, fmt.Errorf("%w: your account isn't linked to an Autotask resource, ask an admin to link it", errActorNotAllowed)
+	case actor.ResourceID <= 0:
+		return actor, fmt.Errorf("%w: resource is required", errInvalidAction)
+	default:
+		actor.SelfReported = true
+	}
+	return actor, nil
+}
+
+// records an action in the audit log. Failures to audit are printed rather than failing the action
+func (w *WebApp) auditAction(ctx context.Context, client *api.Client, actor actionActor, action string, ticketID int64, detail string, actionErr error) {
+	actorName := fmt.Sprintf("resource %d", actor.ResourceID)
+	if resources, err := w.resources.Resolve(ctx, client, []int64{actor.ResourceID}); err == nil {
+		if name := resources[actor.ResourceID].Name(); name != "" {
+			actorName = fmt.Sprintf("%s (%d)", name, actor.ResourceID)
+		}
+	}
+	entry := audit.Entry{
+		User:         actor.Session.Username,
+		Actor:        actorName,
+		ResourceID:   actor.ResourceID,
+		SelfReported: actor.SelfReported,
+		RemoteAddr:   actor.RemoteAddr,
+		Action:       action,
+		TicketID:     ticketID,
+		Detail:       detail,
+	}
+	if actionErr != nil {
+		entry.Error = actionErr.Error()
+	}
+	if err := w.auditLog.Record(entry); err != nil {
+		fmt.Println("Error writing audit log:", err)
+	}
+}
+
+// returns the JSON error body for a failed ticket write. Messages returned by Autotask are listed under "errors"
+func actionErrorBody(err error) map[string]any {
+	body := map[string]any{"error": err.Error()}
+	if messages := apiErrorMessages(err); len(messages) > 0 {
+		body["errors"] = messages
+	}
+	return body
+}
+
+// returns the error messages Autotask returned with err, if any
+func apiErrorMessages(err error) []string {
+	var apiErr *api.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Errors
+	}
+	return nil
+}
+
+// maps errors from ticket writes to http status codes
+func actionErrorStatus(err error) int {
+	var assignedErr *api.AlreadyAssignedError
+	var apiErr *api.APIError
+	switch {
+	case errors.Is(err, errInvalidAction):
+		return http.StatusBadRequest
+	case errors.Is(err, errActorNotAllowed):
+		return http.StatusForbidden
+	case errors.As(err, &assignedErr):
+		return http.StatusConflict
+	case errors.Is(err, api.ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	case errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500:
+		return http.StatusBadRequest
+	case errors.As(err, &apiErr):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,224 @@
+package web
+
+import (
+	"AutoTickets/api"
+	"AutoTickets/audit"
+	"AutoTickets/auth"
+	"AutoTickets/tickets"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fake Autotask for ticket actions: serves the ticket picklists (queue 29683412, priorities 1-3, statuses 1 and 5,
+// issue type 7, source 2), ticket 42 titled "Terminate user account", the roles and names of resources, and
+// accepts ticket updates and notes
+type testAutotask struct {
+	*httptest.Server
+	sync.Mutex
+	ticket map[string]any
+	notes  int
+	// number of Resources queries
+	resourceQueries int
+}
+
+func newTestAutotask(t *testing.T) *testAutotask {
+	t.Helper()
+	picklist := func(name string, values ...int) string {
+		var items []string
+		for _, v := range values {
+			items = append(items, fmt.Sprintf(`{"value":"%d","label":"%s %d"}`, v, name, v))
+		}
+		return fmt.Sprintf(`{"name":%q,"isPickList":true,"picklistValues":[%s]}`, name, strings.Join(items, ","))
+	}
+	fields := strings.Join([]string{
+		picklist("queueID", 29683412),
+		picklist("priority", 1, 2, 3),
+		picklist("status", 1, 5),
+		picklist("issueType", 7),
+		picklist("source", 2),
+		`{"name":"title","isPickList":false}`,
+	}, ",")
+	ta := &testAutotask{ticket: map[string]any{"id": 42, "title": "Terminate user account", "description": "leaver", "status": 1}}
+	ta.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ta.Lock()
+		defer ta.Unlock()
+		switch {
+		case r.URL.Path == "/v1.0/Tickets/entityInformation/fields":
+			fmt.Fprintf(w, `{"fields":[%s]}`, fields)
+		case r.Method == http.MethodGet && r.URL.Path == "/v1.0/Tickets/42":
+			json.NewEncoder(w).Encode(map[string]any{"item": ta.ticket})
+		case r.Method == http.MethodPatch && r.URL.Path == "/v1.0/Tickets":
+			var update map[string]any
+			json.NewDecoder(r.Body).Decode(&update)
+			for k, v := range update {
+				ta.ticket[k] = v
+			}
+			fmt.Fprint(w, `{"itemId":42}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/v1.0/Tickets/42/Notes":
+			ta.notes++
+			fmt.Fprint(w, `{"itemId":1}`)
+		case r.URL.Path == "/v1.0/ResourceRoleDepartments/query":
+			fmt.Fprint(w, `{"items":[{"roleID":11,"isDefault":true}],"pageDetails":{"nextPageUrl":null}}`)
+		case r.URL.Path == "/v1.0/Resources/query":
+			ta.resourceQueries++
+			fmt.Fprint(w, `{"items":[{"id":29682885,"firstName":"Ada","lastName":"Lovelace","email":"ada@example.com","isActive":true},`+
+				`{"id":29682886,"firstName":"Alan","lastName":"Turing","email":"alan@example.com","isActive":true}],"pageDetails":{"nextPageUrl":null}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(ta.Close)
+	return ta
+}
+
+// connects w to the fake Autotask
+func (ta *testAutotask) connect(t *testing.T, w *WebApp) {
+	t.Helper()
+	client := api.NewClient(ta.URL, "", "", "")
+	w.apiConn.set(client)
+	if err := w.metadata.Refresh(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateNewTicket(t *testing.T) {
+	w, _ := newTestApp(t)
+	newTestAutotask(t).connect(t, w)
+
+	valid := api.NewTicket{CompanyID: 174, QueueID: 29683412, Priority: 2, Status: 1, Title: "  Printer offline  "}
+	tests := []struct {
+		name   string
+		modify func(*api.NewTicket)
+		want   []string
+	}{
+		{name: "valid", modify: func(*api.NewTicket) {}},
+		{name: "valid optional picklists", modify: func(t *api.NewTicket) { t.IssueType, t.Source = 7, 2 }},
+		{name: "missing company and title", modify: func(t *api.NewTicket) { t.CompanyID, t.Title = 0, "   " },
+			want: []string{"company is required", "title is required"}},
+		{name: "unknown queue", modify: func(t *api.NewTicket) { t.QueueID = 1 }, want: []string{"queueID 1 is not a valid choice"}},
+		{name: "missing priority", modify: func(t *api.NewTicket) { t.Priority = 0 }, want: []string{"priority 0 is not a valid choice"}},
+		{name: "unknown status", modify: func(t *api.NewTicket) { t.Status = 9 }, want: []string{"status 9 is not a valid choice"}},
+		{name: "unknown optional picklists", modify: func(t *api.NewTicket) { t.IssueType, t.Source = 8, 3 },
+			want: []string{"issueType 8 is not a valid choice", "source 3 is not a valid choice"}},
+	}
+	for _, tt := range tests {
+		ticket := valid
+		tt.modify(&ticket)
+		err := w.validateNewTicket(&ticket)
+		if len(tt.want) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			if ticket.Title != "Printer offline" {
+				t.Errorf("%s: expected the title to be trimmed, got %q", tt.name, ticket.Title)
+			}
+			continue
+		}
+		if !errors.Is(err, errInvalidAction) || actionErrorStatus(err) != http.StatusBadRequest {
+			t.Errorf("%s: expected an invalid action, got %v", tt.name, err)
+			continue
+		}
+		for _, problem := range tt.want {
+			if !strings.Contains(err.Error(), problem) {
+				t.Errorf("%s: expected %q in %q", tt.name, problem, err)
+			}
+		}
+	}
+}
+
+func TestActionResponsesRedacted(t *testing.T) {
+	w, _ := newTestApp(t)
+	newTestAutotask(t).connect(t, w)
+
+	for role, redacted := range map[auth.Role]bool{auth.RoleStandard: true, auth.RolePrivileged: false} {
+		if err := w.users.SetUser("tester", "correct horse", role, 29682885); err != nil {
+			t.Fatal(err)
+		}
+		cookie := testSessionCookie(t, w, role)
+		for _, action := range []struct{ path, body string }{
+			{"/tickets/42/claim", `{"resourceID":29682885}`},
+			{"/tickets/42/status", `{"resourceID":29682885,"status":1}`},
+		} {
+			rec := sendTestRequest(w, http.MethodPost, action.path, action.body, cookie)
+			var ticket tickets.AutotaskTicket
+			json.Unmarshal(rec.Body.Bytes(), &ticket)
+			if rec.Code != http.StatusOK || ticket.ID != 42 {
+				t.Fatalf("%s %s: unexpected response %d %s", role, action.path, rec.Code, rec.Body)
+			}
+			if ticket.Redacted != redacted || (ticket.Title == "Terminate user account") == redacted {
+				t.Errorf("%s %s: expected redacted=%t, got %+v", role, action.path, redacted, ticket)
+			}
+		}
+	}
+}
+
+func TestActionActor(t *testing.T) {
+	w, _ := newTestApp(t)
+	ta := newTestAutotask(t)
+	ta.connect(t, w)
+	if err := w.users.SetUser("ada", "correct horse", auth.RoleStandard, 29682885); err != nil {
+		t.Fatal(err)
+	}
+	// single sign-on users are matched through the active resources the poller loads
+	if err := w.resources.RefreshActive(context.Background(), w.apiConn.get()); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		session    auth.Session
+		resourceID int64
+		status     int
+		// expected audit entry, when the action is allowed
+		entry audit.Entry
+	}{
+		{"linked user, own resource", auth.Session{Username: "ada", Role: auth.RoleStandard}, 0, http.StatusOK,
+			audit.Entry{User: "ada", Actor: "Ada Lovelace (29682885)", ResourceID: 29682885}},
+		{"linked user, other resource", auth.Session{Username: "ada", Role: auth.RoleStandard}, 29682886, http.StatusForbidden, audit.Entry{}},
+		{"unlinked user", auth.Session{Username: "bob", Role: auth.RolePrivileged}, 29682885, http.StatusForbidden, audit.Entry{}},
+		{"unlinked admin", auth.Session{Username: "root", Role: auth.RoleAdmin}, 29682886, http.StatusOK,
+			audit.Entry{User: "root", Actor: "Alan Turing (29682886)", ResourceID: 29682886, SelfReported: true}},
+		{"unlinked admin, no resource", auth.Session{Username: "root", Role: auth.RoleAdmin}, 0, http.StatusBadRequest, audit.Entry{}},
+		{"single sign-on user, by email", auth.Session{Username: "oidc:subject-2", Email: "Alan@example.com", Role: auth.RoleStandard}, 0, http.StatusOK,
+			audit.Entry{User: "oidc:subject-2", Actor: "Alan Turing (29682886)", ResourceID: 29682886}},
+		{"single sign-on user, unknown email", auth.Session{Username: "oidc:subject-3", Email: "eve@example.com", Role: auth.RoleStandard}, 29682886, http.StatusForbidden, audit.Entry{}},
+	}
+	for _, tc := range cases {
+		os.Remove(w.auditLog.FilePath)
+		body := fmt.Sprintf(`{"resourceID":%d,"description":"called the user"}`, tc.resourceID)
+		rec := sendTestRequest(w, http.MethodPost, "/tickets/42/notes", body, testUserCookie(t, w, tc.session))
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected %d, got %d %s", tc.name, tc.status, rec.Code, rec.Body)
+			continue
+		}
+		data, err := os.ReadFile(w.auditLog.FilePath)
+		if tc.status != http.StatusOK {
+			if err == nil {
+				t.Errorf("%s: expected nothing audited, got %s", tc.name, data)
+			}
+			continue
+		}
+		var entry audit.Entry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		want := tc.entry
+		want.Time, want.RemoteAddr, want.Action, want.TicketID, want.Detail = entry.Time, entry.RemoteAddr, "note", 42, "Note from AutoTickets"
+		if entry != want {
+			t.Errorf("%s: expected audit entry %+v, got %+v", tc.name, want, entry)
+		}
+	}
+	ta.Lock()
+	defer ta.Unlock()
+	if ta.resourceQueries != 1 {
+		t.Errorf("expected actions to use the loaded resources, queried %d times", ta.resourceQueries)
+	}
+}
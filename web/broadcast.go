@@ -0,0 +1,438 @@
+package web
+
+import (
+	"AutoTickets/protocol"
+	"AutoTickets/redact"
+	"AutoTickets/tickets"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// messages queued for a client before it is evicted as a slow consumer
+	sendQueueSize = 64
+	// time allowed to write a message to a client
+	writeWait = 10 * time.Second
+	// time allowed between pongs from a websocket client
+	pongWait = 60 * time.Second
+	// interval at which clients are pinged, shorter than pongWait
+	pingInterval = pongWait * 9 / 10
+	// deltas kept per stream, for SSE clients resuming from a Last-Event-ID
+	streamHistory = 50
+)
+
+// ticket feed
+// tickets as last broadcast to clients, one stream per subscribed filter.
+// Held while broadcasting and sending snapshots, so clients see sequence numbers in order
+type ticketFeed struct {
+	sync.Mutex
+	streams map[string]*ticketStream
+	created int
+}
+
+// tickets matching a filter as last broadcast, the sequence number of that broadcast, and the latest deltas.
+// id is unique to this stream, so event ids from a dropped stream or an earlier run are never resumed
+type ticketStream struct {
+	id      string
+	filter  tickets.Filter
+	seq     uint64
+	tickets []tickets.AutotaskTicket
+	history []streamDelta
+}
+
+// a delta, as broadcast with seq
+type streamDelta struct {
+	seq  uint64
+	diff tickets.Diff
+}
+
+// returns the stream for filter, starting it from current if no client is subscribed to it yet.
+// Caller holds the lock
+func (tf *ticketFeed) stream(filter tickets.Filter, current []tickets.AutotaskTicket) *ticketStream {
+	key := filter.Key()
+	if s, ok := tf.streams[key]; ok {
+		return s
+	}
+	if tf.streams == nil {
+		tf.streams = make(map[string]*ticketStream)
+	}
+	tf.created++
+	s := &ticketStream{
+		id:      strconv.FormatInt(time.Now().UnixNano(), 36) + "." + strconv.Itoa(tf.created),
+		filter:  filter,
+		tickets: filter.Apply(current),
+	}
+	tf.streams[key] = s
+	return s
+}
+
+// records a broadcast of diff, returns its sequence number. Caller holds the feed lock
+func (s *ticketStream) record(current []tickets.AutotaskTicket, diff tickets.Diff) uint64 {
+	s.seq++
+	s.tickets = current
+	s.history = append(s.history, streamDelta{s.seq, diff})
+	if len(s.history) > streamHistory {
+		s.history = s.history[len(s.history)-streamHistory:]
+	}
+	return s.seq
+}
+
+// id of the ticket message with seq, used as the SSE event id
+func (s *ticketStream) eventId(seq uint64) string {
+	return s.id + ":" + strconv.FormatUint(seq, 10)
+}
+
+// feed clients
+// used to thread-safely manage every client of the ticket feed, websocket or SSE
+type feedClients struct {
+	sync.Mutex
+	clients map[*feedClient]bool
+}
+
+// removes a client and closes it
+func (fc *feedClients) remove(client *feedClient) {
+	fc.Lock()
+	delete(fc.clients, client)
+	fc.Unlock()
+	client.close()
+}
+
+// a client of the ticket feed: its viewer role and session, the protocol version it speaks, and its own bounded
+// send queue, drained by the transport's writer so a stalled client never delays broadcasts to the others
+type feedClient struct {
+	role       redact.Role
+	sessionID  string
+	version    int
+	remoteAddr string
+	// key of the ticket stream the client is subscribed to, protected by the feedClients lock
+	stream string
+
+	send      chan *outMessage
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// returns a client with an empty send queue
+func newFeedClient(role redact.Role, sessionID string, version int, remoteAddr string) *feedClient {
+	return &feedClient{
+		role:       role,
+		sessionID:  sessionID,
+		version:    version,
+		remoteAddr: remoteAddr,
+		send:       make(chan *outMessage, sendQueueSize),
+		done:       make(chan struct{}),
+	}
+}
+
+// queues m without blocking. A client whose queue is full is closed,
+// returns false if the client is (now) closed
+func (c *feedClient) enqueue(m *outMessage) bool {
+	if c.closed() {
+		return false
+	}
+	select {
+	case c.send <- m:
+		return true
+	default:
+		c.close()
+		return false
+	}
+}
+
+// stops the client's writer. Safe to call more than once
+func (c *feedClient) close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// true once the client is closed
+func (c *feedClient) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// a message encoded once, and sent as is to every client it is queued for
+type outMessage struct {
+	msgType string
+	// set on ticket messages
+	eventId string
+	data    []byte
+
+	prepareOnce sync.Once
+	prepared    *websocket.PreparedMessage
+	prepareErr  error
+}
+
+// encodes msg in a version v envelope
+func newOutMessage[T protocol.ServerMessage](v int, seq uint64, eventId string, msg T) (*outMessage, error) {
+	data, err := protocol.Encode(v, seq, msg)
+	if err != nil {
+		return nil, err
+	}
+	return &outMessage{msgType: msg.MessageType(), eventId: eventId, data: data}, nil
+}
+
+// returns the message framed for websockets, framing it on first use
+func (m *outMessage) websocketMessage() (*websocket.PreparedMessage, error) {
+	m.prepareOnce.Do(func() {
+		m.prepared, m.prepareErr = websocket.NewPreparedMessage(websocket.TextMessage, m.data)
+	})
+	return m.prepared, m.prepareErr
+}
+
+// encodes msg in an envelope of the client's version and queues it
+func queueMessage[T protocol.ServerMessage](c *feedClient, seq uint64, msg T) bool {
+	m, err := newOutMessage(c.version, seq, "", msg)
+	if err != nil {
+		return false
+	}
+	return c.enqueue(m)
+}
+
+// queues the outcome of a command for the client that issued it
+func queueCommandResult(client *feedClient, command string, ticketID int64, err error) {
+	result := protocol.Result{Command: command, TicketID: ticketID, Ok: err == nil}
+	if err != nil {
+		result.Error = err.Error()
+		result.Errors = apiErrorMessages(err)
+	}
+	queueMessage(client, 0, result)
+}
+
+// registers client subscribed to filter, and queues its welcome, its tickets and the status.
+// A lastEventId from the client's stream resumes it with the deltas it missed; otherwise it gets a snapshot
+func (w *WebApp) addClient(client *feedClient, filter tickets.Filter, lastEventId string) {
+	// registered while holding the feed, so the client gets every delta after its snapshot
+	w.feed.Lock()
+	w.clients.Lock()
+	w.clients.clients[client] = true
+	queueMessage(client, 0, protocol.Welcome{Version: client.version, Server: w.serverParams.versionStr, Role: string(client.role)})
+	s := w.feed.stream(filter, w.Tc.GetTickets())
+	client.stream = filter.Key()
+	if !w.queueResume(client, s, lastEventId) {
+		w.queueSnapshot(client, s)
+	}
+	w.clients.Unlock()
+	w.feed.Unlock()
+	queueMessage(client, 0, w.newStatusMessage())
+}
+
+// ticket messages
+
+// identifies the delta message a client receives
+type deltaKey struct {
+	stream  string
+	role    redact.Role
+	version int
+}
+
+// Broadcast changes since the last broadcast to every client: each stream is compared with the
+// open tickets matching its filter, and subscribers of streams that changed are queued the delta, redacted
+// for their role. Each message is encoded once per stream, role and version. Streams nobody is subscribed to are dropped
+func (w *WebApp) broadcastTickets() {
+	w.feed.Lock()
+	defer w.feed.Unlock()
+	w.clients.Lock()
+	defer w.clients.Unlock()
+
+	subscribed := make(map[string]bool)
+	for client := range w.clients.clients {
+		subscribed[client.stream] = true
+	}
+	current := w.Tc.GetTickets()
+	diffs := make(map[string]tickets.Diff)
+	for key, s := range w.feed.streams {
+		if !subscribed[key] {
+			delete(w.feed.streams, key)
+			continue
+		}
+		matched := s.filter.Apply(current)
+		diff := tickets.DiffTickets(s.tickets, matched)
+		if diff.Empty() {
+			continue
+		}
+		s.record(matched, diff)
+		diffs[key] = diff
+	}
+	if len(diffs) == 0 {
+		return
+	}
+
+	encoded := make(map[deltaKey]*outMessage)
+	for client := range w.clients.clients {
+		diff, ok := diffs[client.stream]
+		if !ok {
+			continue
+		}
+		key := deltaKey{client.stream, client.role, client.version}
+		m, ok := encoded[key]
+		if !ok {
+			var err error
+			m, err = w.newDeltaMessage(client, w.feed.streams[client.stream], w.feed.streams[client.stream].seq, diff)
+			if err != nil {
+				w.E.Logger.Error("error encoding delta:", err)
+				return
+			}
+			encoded[key] = m
+		}
+		w.enqueue(client, m)
+	}
+}
+
+// encodes diff, broadcast on s with seq, as client may see it
+func (w *WebApp) newDeltaMessage(client *feedClient, s *ticketStream, seq uint64, diff tickets.Diff) (*outMessage, error) {
+	return newOutMessage(client.version, seq, s.eventId(seq), protocol.Delta{
+		Added:   w.ticketsForRole(client.role, diff.Added),
+		Removed: diff.Removed,
+		Updated: w.ticketsForRole(client.role, diff.Updated),
+	})
+}
+
+// queues m for client, evicting it if its queue is full. Caller holds the feedClients lock
+func (w *WebApp) enqueue(client *feedClient, m *outMessage) {
+	if client.closed() {
+		return
+	}
+	if !client.enqueue(m) {
+		fmt.Println("Evicted slow client:", client.remoteAddr)
+		delete(w.clients.clients, client)
+	}
+}
+
+// queues the last broadcast tickets of stream s for a single client, redacted for its role.
+// Caller holds the feed and feedClients locks
+func (w *WebApp) queueSnapshot(client *feedClient, s *ticketStream) {
+	snapshot := protocol.Snapshot{Filter: s.filter, Tickets: w.ticketsForRole(client.role, s.tickets)}
+	m, err := newOutMessage(client.version, s.seq, s.eventId(s.seq), snapshot)
+	if err != nil {
+		w.E.Logger.Error("error encoding snapshot:", err)
+		return
+	}
+	w.enqueue(client, m)
+}
+
+// queues the deltas of s broadcast after lastEventId. Returns false if lastEventId is not from s,
+// or the deltas are no longer kept, in which case the client needs a snapshot.
+// Caller holds the feed and feedClients locks
+func (w *WebApp) queueResume(client *feedClient, s *ticketStream, lastEventId string) bool {
+	id, seqStr, ok := strings.Cut(lastEventId, ":")
+	if !ok || id != s.id {
+		return false
+	}
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil || seq > s.seq || seq+uint64(len(s.history)) < s.seq {
+		return false
+	}
+	for _, d := range s.history {
+		if d.seq <= seq {
+			continue
+		}
+		m, err := w.newDeltaMessage(client, s, d.seq, d.diff)
+		if err != nil {
+			return false
+		}
+		w.enqueue(client, m)
+	}
+	return true
+}
+
+// queues a fresh snapshot for a client that detected a gap in sequence numbers
+func (w *WebApp) resyncClient(client *feedClient) {
+	w.feed.Lock()
+	defer w.feed.Unlock()
+	w.clients.Lock()
+	defer w.clients.Unlock()
+	if !w.clients.clients[client] {
+		return
+	}
+	if s, ok := w.feed.streams[client.stream]; ok {
+		w.queueSnapshot(client, s)
+	}
+}
+
+// subscribes a client to the stream for filter, and queues a snapshot of that stream
+func (w *WebApp) subscribeClient(client *feedClient, filter tickets.Filter) {
+	w.feed.Lock()
+	defer w.feed.Unlock()
+	w.clients.Lock()
+	defer w.clients.Unlock()
+	if !w.clients.clients[client] {
+		return
+	}
+	s := w.feed.stream(filter, w.Tc.GetTickets())
+	client.stream = filter.Key()
+	w.queueSnapshot(client, s)
+}
+
+// answers a ping with the sequence number of the last ticket message sent to the client
+func (w *WebApp) sendPong(client *feedClient) {
+	w.feed.Lock()
+	defer w.feed.Unlock()
+	w.clients.Lock()
+	defer w.clients.Unlock()
+	if !w.clients.clients[client] {
+		return
+	}
+	var seq uint64
+	if s, ok := w.feed.streams[client.stream]; ok {
+		seq = s.seq
+	}
+	queueMessage(client, seq, protocol.Pong{})
+}
+
+// status messages
+
+// returns the current server status
+func (w *WebApp) newStatusMessage() protocol.Status {
+	usage := w.thresholds.Usage()
+	health := w.apiConn.health()
+	return protocol.Status{
+		LastApiCheck:     w.lastGoodApi.getTime().Format(time.RFC3339),
+		IsActive:         w.serverParams.getActive(),
+		ApiRequestCount:  usage.Count,
+		ApiRequestLimit:  usage.Limit,
+		PollIntervalSecs: int(w.pollInterval.get().Seconds()),
+
+		ConsecutiveFailures: health.ConsecutiveFailures,
+		BreakerState:        health.BreakerState,
+	}
+}
+
+// Broadcast status to every client every 10 minutes
+func (w *WebApp) periodicallyBroadcastStatus() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		w.broadcastStatus()
+	}
+}
+
+// broadcasts status to every client, encoded once per protocol version
+func (w *WebApp) broadcastStatus() {
+	sm := w.newStatusMessage()
+	encoded := make(map[int]*outMessage)
+	w.clients.Lock()
+	defer w.clients.Unlock()
+	for client := range w.clients.clients {
+		m, ok := encoded[client.version]
+		if !ok {
+			var err error
+			m, err = newOutMessage(client.version, 0, "", sm)
+			if err != nil {
+				w.E.Logger.Error("error encoding status:", err)
+				return
+			}
+			encoded[client.version] = m
+		}
+		w.enqueue(client, m)
+	}
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"AutoTickets/protocol"
+	"AutoTickets/tickets"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Server-Sent Events handler: streams the messages websocket clients receive, for browsers and proxies
+// that can't upgrade to a websocket. The view / filter comes from the query (view, queue, company, priority),
+// and the protocol version from v. Reconnecting clients send Last-Event-ID and receive the deltas they missed
+func (w *WebApp) handleEvents(c echo.Context) error {
+	version := protocol.Version
+	if v := c.QueryParam("v"); v != "" {
+		requested, err := strconv.Atoi(v)
+		negotiated, ok := protocol.Negotiate([]int{requested})
+		if err != nil || !ok {
+			return c.JSON(http.StatusBadRequest, map[string]any{
+				"error":             "Unsupported protocol version",
+				"supportedVersions": protocol.SupportedVersions,
+			})
+		}
+		version = negotiated
+	}
+	filter, err := filterFromQuery(c, tickets.ViewUnassigned)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set(echo.HeaderCacheControl, "no-cache")
+	resp.Header().Set(echo.HeaderConnection, "keep-alive")
+	// stops nginx buffering the stream
+	resp.Header().Set("X-Accel-Buffering", "no")
+	resp.WriteHeader(http.StatusOK)
+	resp.Flush()
+
+	client := newFeedClient(w.viewerRole(c), requestSession(c).ID, version, c.RealIP())
+	w.addClient(client, filter, c.Request().Header.Get("Last-Event-ID"))
+	defer w.clients.remove(client)
+
+	rc := http.NewResponseController(resp.Writer)
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case m := <-client.send:
+			rc.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := writeEvent(resp, m); err != nil {
+				return nil
+			}
+		case <-ticker.C:
+			// comment line, keeps proxies from closing an idle stream
+			rc.SetWriteDeadline(time.Now().Add(writeWait))
+			if _, err := fmt.Fprint(resp, ": ping\n\n"); err != nil {
+				return nil
+			}
+			resp.Flush()
+		case <-client.done:
+			return nil
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+// writes m as an SSE event named after its message type. Ticket messages carry an id to resume from
+func writeEvent(resp *echo.Response, m *outMessage) error {
+	var b strings.Builder
+	if m.eventId != "" {
+		fmt.Fprintf(&b, "id: %s\n", m.eventId)
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", m.msgType, m.data)
+	if _, err := fmt.Fprint(resp, b.String()); err != nil {
+		return err
+	}
+	resp.Flush()
+	return nil
+}
+
+// reads a ticket filter from the view, queue, company and priority query parameters.
+// Lists are comma separated, view defaults to defaultView
+func filterFromQuery(c echo.Context, defaultView string) (tickets.Filter, error) {
+	filter := tickets.Filter{View: c.QueryParam("view")}
+	if filter.View == "" {
+		filter.View = defaultView
+	}
+	var err error
+	if filter.QueueIDs, err = parseIntList[int](c.QueryParam("queue")); err != nil {
+		return filter, fmt.Errorf("Invalid queue: %w", err)
+	}
+	if filter.CompanyIDs, err = parseIntList[int64](c.QueryParam("company")); err != nil {
+		return filter, fmt.Errorf("Invalid company: %w", err)
+	}
+	if filter.Priorities, err = parseIntList[int](c.QueryParam("priority")); err != nil {
+		return filter, fmt.Errorf("Invalid priority: %w", err)
+	}
+	return filter, filter.Validate()
+}
+
+// parses a comma separated list of integers. An empty string is an empty list
+func parseIntList[T int | int64](s string) ([]T, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	values := make([]T, 0, len(parts))
+	for _, part := range parts {
+		v, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, T(v))
+	}
+	return values, nil
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"AutoTickets/auth"
+	"AutoTickets/protocol"
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// an SSE event
+type testEvent struct {
+	id, event, data string
+}
+
+// opens /events with a standard session and lastEventId, returns a function reading the next event
+func openTestEvents(t *testing.T, w *WebApp, url, lastEventId string) func() testEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req.Header.Set("Cookie", testSessionCookie(t, w, auth.RoleStandard))
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error opening events: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	events := make(chan testEvent)
+	go func() {
+		var e testEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if e.event != "" {
+					events <- e
+				}
+				e = testEvent{}
+			case strings.HasPrefix(line, "id: "):
+				e.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				e.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return func() testEvent {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for event")
+			return testEvent{}
+		}
+	}
+}
+
+func TestEventsResume(t *testing.T) {
+	w, srv := newTestApp(t)
+	next := openTestEvents(t, w, srv.URL+"/events", "")
+	for _, want := range []string{protocol.TypeWelcome, protocol.TypeSnapshot, protocol.TypeStatus} {
+		if e := next(); e.event != want {
+			t.Fatalf("expected %s event, got %+v", want, e)
+		}
+	}
+
+	setTestTickets(w, 2, 1)
+	w.broadcastTickets()
+	first := next()
+	if first.event != protocol.TypeDelta || first.id == "" || !strings.Contains(first.data, `"seq":1`) {
+		t.Fatalf("unexpected delta event %+v", first)
+	}
+	setTestTickets(w, 3, 2)
+	w.broadcastTickets()
+	if e := next(); e.event != protocol.TypeDelta || !strings.Contains(e.data, `"seq":2`) {
+		t.Fatalf("unexpected delta event %+v", e)
+	}
+
+	// resuming from the first delta replays the second, instead of sending a snapshot
+	resumed := openTestEvents(t, w, srv.URL+"/events", first.id)
+	if e := resumed(); e.event != protocol.TypeWelcome {
+		t.Fatalf("expected welcome event, got %+v", e)
+	}
+	if e := resumed(); e.event != protocol.TypeDelta || !strings.Contains(e.data, `"seq":2`) {
+		t.Fatalf("expected missed delta, got %+v", e)
+	}
+
+	// an id from another stream gets a snapshot
+	other := openTestEvents(t, w, srv.URL+"/events", "unknown:1")
+	other()
+	if e := other(); e.event != protocol.TypeSnapshot || !strings.Contains(e.data, `"seq":2`) {
+		t.Fatalf("expected snapshot, got %+v", e)
+	}
+}
+
+func TestEventsInvalidQuery(t *testing.T) {
+	w, srv := newTestApp(t)
+	for _, query := range []string{"?view=mine", "?queue=a", "?v=9"} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events"+query, nil)
+		req.Header.Set("Cookie", testSessionCookie(t, w, auth.RoleStandard))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package web
+
+import (
+	"AutoTickets/auth"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// cookie binding a single sign-on login to the browser that started it
+const oidcStateCookie = "autotickets_oidc_state"
+
+// path of the single sign-on routes, and of the state cookie
+const oidcPath = "/login/oidc"
+
+// seconds the state cookie lives, as long as the provider has to redirect back
+const oidcStateMaxAge = 600
+
+// sends the browser to the identity provider, remembering the login's state in a cookie
+func (w *WebApp) handleOidcLogin(c echo.Context) error {
+	if w.oidc == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Single sign-on is not configured"})
+	}
+	authUrl, state, err := w.oidc.AuthCodeURL(c.Request().Context(), safeNext(c.QueryParam("next")))
+	if err != nil {
+		fmt.Println("Error starting single sign-on:", err)
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "Identity provider unavailable"})
+	}
+	setOidcStateCookie(c, state, oidcStateMaxAge)
+	return c.Redirect(http.StatusFound, authUrl)
+}
+
+// completes a login when the identity provider redirects back: checks the state against the cookie,
+// redeems the code, and starts a session with the role mapped from the user's groups
+func (w *WebApp) handleOidcCallback(c echo.Context) error {
+	if w.oidc == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Single sign-on is not configured"})
+	}
+	if providerErr := c.QueryParam("error"); providerErr != "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Sign in refused by the identity provider: " + providerErr})
+	}
+	state := c.QueryParam("state")
+	cookie, err := c.Cookie(oidcStateCookie)
+	setOidcStateCookie(c, "", -1)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Login state does not match, please sign in again"})
+	}
+
+	identity, next, err := w.oidc.Exchange(c.Request().Context(), state, c.QueryParam("code"))
+	switch {
+	case errors.Is(err, auth.ErrUnmappedUser):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	case errors.Is(err, auth.ErrUnknownLoginState):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case err != nil:
+		fmt.Println("Error completing single sign-on:", err)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Single sign-on failed"})
+	}
+	session := auth.Session{Username: identity.Username, DisplayName: identity.DisplayName, Email: identity.Email, Role: identity.Role}
+	if err := w.startSession(c, session); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start session"})
+	}
+	return c.Redirect(http.StatusSeeOther, safeNext(next))
+}
+
+// sets the state cookie, scoped to the single sign-on routes. A negative maxAge clears it
+func setOidcStateCookie(c echo.Context, state string, maxAge int) {
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcPath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   c.Scheme() == "https",
+	})
+}
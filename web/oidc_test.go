@@ -0,0 +1,119 @@
+package web
+
+import (
+	"AutoTickets/auth"
+	"AutoTickets/auth/oidctest"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// web app signing users in through a mock provider, mapping groups with roles
+func newTestOidcApp(t *testing.T, p *oidctest.Provider, roles string) *WebApp {
+	t.Helper()
+	groupRoles, err := auth.ParseGroupRoles(roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oidc, err := auth.NewOIDC(auth.OIDCConfig{
+		Issuer:       p.Issuer(),
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  "http://tickets.test/login/oidc/callback",
+		GroupRoles:   groupRoles,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := newTestSsoApp(t, oidc)
+	return w
+}
+
+// starts a single sign-on login for next, follows the provider's redirect,
+// and returns the callback path and the state cookie set by the app
+func startTestSso(t *testing.T, w *WebApp, next string) (string, string) {
+	t.Helper()
+	rec := sendTestRequest(w, http.MethodGet, "/login/oidc?next="+url.QueryEscape(next), "", "")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect to the provider, got %d %s", rec.Code, rec.Body)
+	}
+	var stateCookie string
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			stateCookie = (&http.Cookie{Name: cookie.Name, Value: cookie.Value}).String()
+		}
+	}
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || callback.Path != "/login/oidc/callback" {
+		t.Fatalf("expected the provider to redirect to the callback, got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	return callback.RequestURI(), stateCookie
+}
+
+func TestSsoLogin(t *testing.T) {
+	p := oidctest.NewProvider(t, "tickets", "secret")
+	p.SetClaims(map[string]any{"preferred_username": "Carol", "groups": []string{"hr"}})
+	w := newTestOidcApp(t, p, "helpdesk=standard,hr=privileged")
+
+	if w.setupToken() != "" {
+		t.Error("expected no setup token with single sign-on")
+	}
+	if rec := sendTestRequest(w, http.MethodGet, "/login", "", ""); !strings.Contains(rec.Body.String(), `action="/login/oidc"`) {
+		t.Errorf("expected the login page to offer single sign-on, got %d", rec.Code)
+	}
+
+	callback, stateCookie := startTestSso(t, w, "/api/v1/status")
+	rec := sendTestRequest(w, http.MethodGet, callback, "", stateCookie)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/api/v1/status" {
+		t.Fatalf("expected redirect to next, got %d %q %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	session := responseSessionCookie(t, rec)
+
+	var viewer map[string]string
+	json.Unmarshal(sendTestRequest(w, http.MethodGet, "/viewer", "", session).Body.Bytes(), &viewer)
+	if viewer["username"] != "oidc:subject-1" || viewer["displayName"] != "Carol" || viewer["role"] != string(auth.RolePrivileged) {
+		t.Errorf("unexpected viewer %v", viewer)
+	}
+	// a local user of the same name is someone else
+	if w.sessions.DeleteUser("carol") != 0 {
+		t.Error("expected ending a local user's sessions to leave the single sign-on session")
+	}
+
+	// the code and state are single use
+	if rec := sendTestRequest(w, http.MethodGet, callback, "", stateCookie); rec.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback: expected 400, got %d", rec.Code)
+	}
+}
+
+func TestSsoCallbackChecks(t *testing.T) {
+	p := oidctest.NewProvider(t, "tickets", "secret")
+	p.SetClaims(map[string]any{"groups": []string{"contractors"}})
+	w := newTestOidcApp(t, p, "helpdesk=standard")
+
+	// the state must come back to the browser that started the login
+	callback, _ := startTestSso(t, w, "/")
+	if rec := sendTestRequest(w, http.MethodGet, callback, "", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("missing state cookie: expected 400, got %d", rec.Code)
+	}
+	callback, stateCookie := startTestSso(t, w, "/")
+	if rec := sendTestRequest(w, http.MethodGet, callback, "", stateCookie); rec.Code != http.StatusForbidden {
+		t.Errorf("unmapped groups: expected 403, got %d", rec.Code)
+	}
+	if rec := sendTestRequest(w, http.MethodGet, "/login/oidc/callback?error=access_denied", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("provider error: expected 401, got %d", rec.Code)
+	}
+
+	w, _ = newTestApp(t)
+	if rec := sendTestRequest(w, http.MethodGet, "/login/oidc", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("without single sign-on: expected 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,253 @@
+package web
+
+import (
+	"AutoTickets/protocol"
+	"AutoTickets/tickets"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// OpenAPI document describing the /api/v1 routes
+//
+//go:embed openapi.json
+var openApiDoc []byte
+
+const (
+	// tickets per page when no limit is given
+	defaultPageLimit = 50
+	// largest page of tickets
+	maxPageLimit = 500
+	// seconds /tickets/wait blocks when no timeout is given
+	defaultWaitSecs = 30
+	// longest /tickets/wait timeout, in seconds
+	maxWaitSecs = 120
+)
+
+// page of tickets
+type ticketPage struct {
+	Items  []tickets.AutotaskTicket `json:"items"`
+	Total  int                      `json:"total"`
+	Limit  int                      `json:"limit"`
+	Offset int                      `json:"offset"`
+}
+
+// tickets after a wait, and the hash to wait on next
+type ticketWait struct {
+	Hash    string                   `json:"hash"`
+	Changed bool                     `json:"changed"`
+	Tickets []tickets.AutotaskTicket `json:"tickets"`
+}
+
+// server status
+type apiStatusResponse struct {
+	LastApiCheck        time.Time `json:"lastApiCheck,omitzero"`
+	IsActive            bool      `json:"isActive"`
+	NextPoll            time.Time `json:"nextPoll,omitzero"`
+	PollIntervalSecs    int       `json:"pollIntervalSecs"`
+	ApiRequestCount     int       `json:"apiRequestCount"`
+	ApiRequestLimit     int       `json:"apiRequestLimit"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	BreakerState        string    `json:"breakerState"`
+	SecretsLoaded       bool      `json:"secretsLoaded"`
+}
+
+// server version
+type apiVersionResponse struct {
+	Version                   string `json:"version"`
+	ProtocolVersion           int    `json:"protocolVersion"`
+	SupportedProtocolVersions []int  `json:"supportedProtocolVersions"`
+}
+
+// orders two tickets by a field, ascending
+var ticketSorts = map[string]func(a, b tickets.AutotaskTicket) bool{
+	"id":               func(a, b tickets.AutotaskTicket) bool { return a.ID < b.ID },
+	"title":            func(a, b tickets.AutotaskTicket) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) },
+	"priority":         func(a, b tickets.AutotaskTicket) bool { return a.Priority < b.Priority },
+	"status":           func(a, b tickets.AutotaskTicket) bool { return a.Status < b.Status },
+	"createDate":       func(a, b tickets.AutotaskTicket) bool { return a.CreateDate.Before(b.CreateDate) },
+	"dueDateTime":      func(a, b tickets.AutotaskTicket) bool { return a.DueDateTime.Before(b.DueDateTime) },
+	"lastActivityDate": func(a, b tickets.AutotaskTicket) bool { return a.LastActivityDate.Before(b.LastActivityDate) },
+}
+
+// registers the /api/v1 routes
+func (w *WebApp) registerApiV1() {
+	g := w.E.Group("/api/v1")
+	g.GET("/tickets", w.handleApiTickets)
+	g.GET("/tickets/wait", w.handleApiTicketsWait)
+	g.GET("/tickets/:id", w.handleApiTicket)
+	g.GET("/status", w.handleApiStatus)
+	g.GET("/version", w.handleApiVersion)
+	g.GET("/openapi.json", w.handleApiDoc)
+}
+
+// returns a page of open tickets matching the query filters (view, queue, company, priority),
+// ordered by sort (a field, "-" prefixed for descending). Redacted for the viewer's role
+// before sorting and counting
+func (w *WebApp) handleApiTickets(c echo.Context) error {
+	filter, err := filterFromQuery(c, tickets.ViewOpen)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	limit, err := queryInt(c, "limit", defaultPageLimit)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and " + strconv.Itoa(maxPageLimit)})
+	}
+	offset, err := queryInt(c, "offset", 0)
+	if err != nil || offset < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "offset must not be negative"})
+	}
+
+	sortBy := c.QueryParam("sort")
+	if sortBy == "" {
+		sortBy = "-createDate"
+	}
+	field, descending := strings.CutPrefix(sortBy, "-")
+	less, ok := ticketSorts[field]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown sort field " + strconv.Quote(field)})
+	}
+
+	matched := w.ticketsForRole(w.viewerRole(c), filter.Apply(w.Tc.GetTickets()))
+	sort.SliceStable(matched, func(i, j int) bool {
+		if descending {
+			return less(matched[j], matched[i])
+		}
+		return less(matched[i], matched[j])
+	})
+
+	page := ticketPage{Items: []tickets.AutotaskTicket{}, Total: len(matched), Limit: limit, Offset: offset}
+	if offset < len(matched) {
+		page.Items = matched[offset:min(offset+limit, len(matched))]
+	}
+	return jsonWithETag(c, page)
+}
+
+// blocks until the ticket hash differs from since, or timeout seconds pass, then returns the
+// current hash and the open tickets matching the query filters, redacted for the viewer's role
+func (w *WebApp) handleApiTicketsWait(c echo.Context) error {
+	filter, err := filterFromQuery(c, tickets.ViewOpen)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	timeoutSecs, err := queryInt(c, "timeout", defaultWaitSecs)
+	if err != nil || timeoutSecs < 1 || timeoutSecs > maxWaitSecs {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "timeout must be between 1 and " + strconv.Itoa(maxWaitSecs) + " seconds"})
+	}
+	since := c.QueryParam("since")
+
+	timer := time.NewTimer(time.Duration(timeoutSecs) * time.Second)
+	defer timer.Stop()
+	hash, changed := w.Tc.Watch()
+	for hash == since {
+		select {
+		case <-changed:
+			hash, changed = w.Tc.Watch()
+		case <-timer.C:
+			return w.writeTicketWait(c, filter, hash, false)
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+	return w.writeTicketWait(c, filter, hash, true)
+}
+
+// writes the result of a /tickets/wait request. The tickets are read after the hash,
+// so they are at least as new as it
+func (w *WebApp) writeTicketWait(c echo.Context, filter tickets.Filter, hash string, changed bool) error {
+	return c.JSON(http.StatusOK, ticketWait{
+		Hash:    hash,
+		Changed: changed,
+		Tickets: w.ticketsForRole(w.viewerRole(c), filter.Apply(w.Tc.GetTickets())),
+	})
+}
+
+// returns a single open ticket, redacted for the viewer's role
+func (w *WebApp) handleApiTicket(c echo.Context) error {
+	ticketID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid ticket id"})
+	}
+	for _, ticket := range w.Tc.GetTickets() {
+		if ticket.ID == ticketID {
+			return jsonWithETag(c, w.ticketForRole(w.viewerRole(c), ticket))
+		}
+	}
+	return c.JSON(http.StatusNotFound, map[string]string{"error": "Ticket not found"})
+}
+
+// returns the polling status of the server
+func (w *WebApp) handleApiStatus(c echo.Context) error {
+	usage := w.thresholds.Usage()
+	health := w.apiConn.health()
+	return jsonWithETag(c, apiStatusResponse{
+		LastApiCheck:        w.lastGoodApi.getTime(),
+		IsActive:            w.serverParams.getActive(),
+		NextPoll:            w.pollInterval.nextPoll(),
+		PollIntervalSecs:    int(w.pollInterval.get().Seconds()),
+		ApiRequestCount:     usage.Count,
+		ApiRequestLimit:     usage.Limit,
+		ConsecutiveFailures: health.ConsecutiveFailures,
+		BreakerState:        health.BreakerState,
+		SecretsLoaded:       w.Sc.SecretsAreLoaded(),
+	})
+}
+
+// returns the server and protocol versions
+func (w *WebApp) handleApiVersion(c echo.Context) error {
+	return jsonWithETag(c, apiVersionResponse{
+		Version:                   w.serverParams.versionStr,
+		ProtocolVersion:           protocol.Version,
+		SupportedProtocolVersions: protocol.SupportedVersions,
+	})
+}
+
+// returns the OpenAPI document
+func (w *WebApp) handleApiDoc(c echo.Context) error {
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, openApiDoc)
+}
+
+// writes v as JSON with an ETag of its content, or 304 if the request's If-None-Match matches it.
+// Bodies are redacted per viewer, so they vary with the viewer's credentials
+func jsonWithETag(c echo.Context, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Response().Header().Set(echo.HeaderVary, "Cookie, Authorization")
+	c.Response().Header().Set("ETag", etag)
+	if etagMatches(c.Request().Header.Get("If-None-Match"), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+// true if an If-None-Match header value matches etag. Weak comparison, as RFC 9110 requires for If-None-Match
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// reads an integer query parameter, def if it is not set
+func queryInt(c echo.Context, name string, def int) (int, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,236 @@
+package web
+
+import (
+	"AutoTickets/auth"
+	"AutoTickets/tickets"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// performs a GET against the app with a standard session, with If-None-Match if etag is set
+func apiGet(t *testing.T, w *WebApp, target, etag string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Cookie", testSessionCookie(t, w, auth.RoleStandard))
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	rec := httptest.NewRecorder()
+	w.E.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestApiTicketsFilterSortPage(t *testing.T) {
+	w, _ := newTestApp(t)
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := []tickets.AutotaskTicket{
+		{ID: 1, Title: "a", QueueID: 5, Priority: 1, CreateDate: base},
+		{ID: 2, Title: "b", QueueID: 5, Priority: 3, CreateDate: base.Add(time.Hour), AssignedResourceID: "7"},
+		{ID: 3, Title: "c", QueueID: 6, Priority: 2, CreateDate: base.Add(2 * time.Hour)},
+		{ID: 4, Title: "d", QueueID: 5, Priority: 2, CreateDate: base.Add(3 * time.Hour)},
+	}
+	w.Tc.SetTickets(&ts)
+
+	tests := []struct {
+		query string
+		ids   []int64
+		total int
+	}{
+		{"", []int64{4, 3, 2, 1}, 4},
+		{"?view=unassigned", []int64{4, 3, 1}, 3},
+		{"?queue=5&sort=priority", []int64{1, 4, 2}, 3},
+		{"?sort=id&limit=2&offset=1", []int64{2, 3}, 4},
+		{"?offset=10", []int64{}, 4},
+	}
+	for _, tt := range tests {
+		rec := apiGet(t, w, "/api/v1/tickets"+tt.query, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status %d %s", tt.query, rec.Code, rec.Body)
+		}
+		var page ticketPage
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		ids := []int64{}
+		for _, ticket := range page.Items {
+			ids = append(ids, ticket.ID)
+		}
+		if page.Total != tt.total || !equalIds(ids, tt.ids) {
+			t.Errorf("%q: got %v of %d, want %v of %d", tt.query, ids, page.Total, tt.ids, tt.total)
+		}
+	}
+
+	for _, query := range []string{"?sort=bogus", "?limit=0", "?limit=501", "?offset=-1", "?queue=x", "?view=closed"} {
+		if rec := apiGet(t, w, "/api/v1/tickets"+query, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func equalIds(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sorting by title must not reveal the order of titles a standard viewer sees redacted
+func TestApiTicketsSortRedacted(t *testing.T) {
+	w, _ := newTestApp(t)
+	ts := []tickets.AutotaskTicket{
+		{ID: 1, Title: "Terminate zoe"},
+		{ID: 2, Title: "Terminate adam"},
+		{ID: 3, Title: "Printer offline"},
+	}
+	w.Tc.SetTickets(&ts)
+
+	for _, tt := range []struct {
+		role auth.Role
+		sort string
+		ids  []int64
+	}{
+		{auth.RolePrivileged, "title", []int64{3, 2, 1}},
+		{auth.RolePrivileged, "-title", []int64{1, 2, 3}},
+		// both read "Sensitive - view on web", so they keep their order either way
+		{auth.RoleStandard, "title", []int64{3, 1, 2}},
+		{auth.RoleStandard, "-title", []int64{1, 2, 3}},
+		{auth.RoleStandard, "title&limit=1&offset=1", []int64{1}},
+	} {
+		rec := sendTestRequest(w, http.MethodGet, "/api/v1/tickets?sort="+tt.sort, "", testSessionCookie(t, w, tt.role))
+		var page ticketPage
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatalf("%s %s: %v", tt.role, tt.sort, err)
+		}
+		ids := []int64{}
+		for _, ticket := range page.Items {
+			ids = append(ids, ticket.ID)
+		}
+		if !equalIds(ids, tt.ids) || page.Total != 3 {
+			t.Errorf("%s %s: expected %v of 3, got %v of %d", tt.role, tt.sort, tt.ids, ids, page.Total)
+		}
+	}
+}
+
+func TestApiTicketById(t *testing.T) {
+	w, _ := newTestApp(t)
+	ts := []tickets.AutotaskTicket{{ID: 42, Title: "Terminate user account"}}
+	w.Tc.SetTickets(&ts)
+
+	rec := apiGet(t, w, "/api/v1/tickets/42", "")
+	var ticket tickets.AutotaskTicket
+	if err := json.Unmarshal(rec.Body.Bytes(), &ticket); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status %d %s", rec.Code, rec.Body)
+	}
+	if !ticket.Redacted {
+		t.Errorf("expected ticket to be redacted for a standard viewer")
+	}
+	if rec := apiGet(t, w, "/api/v1/tickets/43", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+	if rec := apiGet(t, w, "/api/v1/tickets/x", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rec.Code)
+	}
+}
+
+func TestApiETag(t *testing.T) {
+	w, _ := newTestApp(t)
+	setTestTickets(w, 2, 1)
+
+	rec := apiGet(t, w, "/api/v1/tickets", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d %q", rec.Code, etag)
+	}
+	for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		if rec := apiGet(t, w, "/api/v1/tickets", ifNoneMatch); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Errorf("%q: expected empty 304, got %d", ifNoneMatch, rec.Code)
+		}
+	}
+
+	setTestTickets(w, 2, 2)
+	if rec := apiGet(t, w, "/api/v1/tickets", etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("expected 200 with a new ETag after tickets changed, got %d", rec.Code)
+	}
+}
+
+// every /api/v1 route must be described in the OpenAPI document
+func TestOpenApiDocumentsRoutes(t *testing.T) {
+	w, _ := newTestApp(t)
+	rec := apiGet(t, w, "/api/v1/openapi.json", "")
+	var doc struct {
+		Paths map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+	for _, route := range w.E.Routes() {
+		path, ok := strings.CutPrefix(route.Path, "/api/v1")
+		if !ok {
+			continue
+		}
+		path = strings.ReplaceAll(path, ":id", "{id}")
+		if _, ok := doc.Paths[path]; !ok {
+			t.Errorf("route %s %s is missing from the OpenAPI document", route.Method, route.Path)
+		}
+	}
+}
+
+func TestApiTicketsWait(t *testing.T) {
+	w, _ := newTestApp(t)
+	setTestTickets(w, 2, 1)
+	w.Tc.CheckForNewHash()
+	hash := w.Tc.GetCurrentHash()
+
+	decode := func(rec *httptest.ResponseRecorder) ticketWait {
+		t.Helper()
+		var result ticketWait
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("status %d %s", rec.Code, rec.Body)
+		}
+		return result
+	}
+
+	// a stale hash returns at once
+	if result := decode(apiGet(t, w, "/api/v1/tickets/wait?since=stale", "")); !result.Changed || result.Hash != hash || len(result.Tickets) != 2 {
+		t.Errorf("expected the current tickets for a stale hash, got %+v", result)
+	}
+
+	// the current hash waits for the timeout
+	start := time.Now()
+	if result := decode(apiGet(t, w, "/api/v1/tickets/wait?timeout=1&since="+hash, "")); result.Changed || result.Hash != hash {
+		t.Errorf("expected no change, got %+v", result)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("returned after %v, before the timeout", elapsed)
+	}
+
+	// a new hash wakes the waiter
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- apiGet(t, w, "/api/v1/tickets/wait?timeout=10&since="+hash, "") }()
+	time.Sleep(50 * time.Millisecond)
+	setTestTickets(w, 3, 2)
+	w.Tc.CheckForNewHash()
+	select {
+	case rec := <-done:
+		if result := decode(rec); !result.Changed || result.Hash == hash || len(result.Tickets) != 3 {
+			t.Errorf("expected the new tickets, got %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter not woken by a new hash")
+	}
+
+	for _, query := range []string{"?timeout=0", "?timeout=121", "?view=closed"} {
+		if rec := apiGet(t, w, "/api/v1/tickets/wait"+query, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
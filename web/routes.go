@@ -1,8 +1,14 @@
 package web
 
 import (
+	"AutoTickets/api"
+	"context"
+	"fmt"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
@@ -12,13 +18,21 @@ type serverInfo struct {
 	ApiPollSecs    int
 	ExecutablePath string
 	Version        string
+	Username       string
+	IsAdmin        bool
+	// where unlockSecrets.html sends the password
+	UnlockPath string
 }
 
 //  Route Handlers
 
-// forward to secrets handler if secrets aren't loaded. Otherwise render index
+// forward admins to secrets handler if secrets aren't loaded. Otherwise render index
 func (w *WebApp) handleRoot(c echo.Context) error {
+	session := requestSession(c)
 	if !w.Sc.SecretsAreLoaded() {
+		if !session.Role.IsAdmin() {
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "API secrets are locked, an admin must sign in and unlock them"})
+		}
 		return c.Redirect(http.StatusSeeOther, "/secrets")
 	}
 	executablePath, err := os.Executable()
@@ -29,6 +43,8 @@ func (w *WebApp) handleRoot(c echo.Context) error {
 		ApiPollSecs:    w.serverParams.pollRate,
 		ExecutablePath: executablePath,
 		Version:        w.serverParams.versionStr,
+		Username:       session.DisplayName,
+		IsAdmin:        session.Role.IsAdmin(),
 	}
 	return c.Render(http.StatusOK, "index.html", si)
 }
@@ -38,7 +54,8 @@ func (w *WebApp) handleSecrets(c echo.Context) error {
 
 	if !w.Sc.SecretsAreLoaded() {
 		si := serverInfo{
-			Version: w.serverParams.versionStr,
+			Version:    w.serverParams.versionStr,
+			UnlockPath: "/submitSecrets",
 		}
 		if w.Sc.EncFilePresent() {
 			return c.Render(http.StatusOK, "unlockSecrets.html", si)
@@ -64,7 +81,11 @@ func (w *WebApp) handleReceiveSecrets(c echo.Context) error {
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to decrypt API Key"})
 		}
-		go w.pollApi()
+		go w.connectAndPollApi(false)
+		// seals the users created at setup, or opens a users file left locked by single sign-on admins
+		if err := w.users.Open([]byte(submission.Password)); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "API secrets unlocked, but failed to open the users file: " + err.Error()})
+		}
 		return c.Redirect(http.StatusSeeOther, "/")
 	}
 
@@ -73,10 +94,143 @@ func (w *WebApp) handleReceiveSecrets(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "API Key and Password are required"})
 	}
 	w.Sc.SetSecrets(submission.IntegrationCode, submission.Secret, submission.Username)
-	go w.pollApi()
+	go w.connectAndPollApi(true)
 	err := w.Sc.EncryptToDisk([]byte(submission.Password))
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save secrets"})
 	}
+	if err := w.users.SetKey([]byte(submission.Password)); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "API secrets saved, but failed to save the users file: " + err.Error()})
+	}
 	return c.Redirect(http.StatusSeeOther, "/")
 }
+
+// returns open ticket counts per assigned resource
+func (w *WebApp) handleRescIdCount(c echo.Context) error {
+	return c.JSON(http.StatusOK, w.getRescIdCount())
+}
+
+// returns active resources, used to choose who claims tickets
+func (w *WebApp) handleResources(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), actionTimeout)
+	defer cancel()
+	client, err := w.getApiClient(ctx)
+	if err != nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+	}
+	resources, err := client.GetActiveResources(ctx)
+	if err != nil {
+		return c.JSON(actionErrorStatus(err), actionErrorBody(err))
+	}
+	return c.JSON(http.StatusOK, resources)
+}
+
+// returns the values and labels of a ticket picklist field, sorted by label
+func (w *WebApp) handlePicklist(c echo.Context) error {
+	picklist := w.metadata.Picklist(c.Param("field"))
+	if picklist == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown picklist"})
+	}
+	type picklistValue struct {
+		Value int    `json:"value"`
+		Label string `json:"label"`
+	}
+	values := make([]picklistValue, 0, len(picklist))
+	for value, label := range picklist {
+		values = append(values, picklistValue{Value: value, Label: label})
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Label < values[j].Label })
+	return c.JSON(http.StatusOK, values)
+}
+
+// assigns the ticket in the path to the submitted resource
+func (w *WebApp) handleClaimTicket(c echo.Context) error {
+	ticketID, submission, err := bindAction(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	ticket, err := w.claimTicket(newActionActor(requestSession(c), submission.ResourceID, c.RealIP()), ticketID, submission.RoleID)
+	if err != nil {
+		return c.JSON(actionErrorStatus(err), actionErrorBody(err))
+	}
+	return c.JSON(http.StatusOK, w.ticketForRole(w.viewerRole(c), ticket))
+}
+
+// posts the submitted note to the ticket in the path
+func (w *WebApp) handleAddTicketNote(c echo.Context) error {
+	ticketID, submission, err := bindAction(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	err = w.addTicketNote(newActionActor(requestSession(c), submission.ResourceID, c.RealIP()), ticketID, submission.Title, submission.Description)
+	if err != nil {
+		return c.JSON(actionErrorStatus(err), actionErrorBody(err))
+	}
+	return c.JSON(http.StatusOK, map[string]bool{"ok": true})
+}
+
+// moves the ticket in the path to the submitted status
+func (w *WebApp) handleSetTicketStatus(c echo.Context) error {
+	ticketID, submission, err := bindAction(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	ticket, err := w.setTicketStatus(newActionActor(requestSession(c), submission.ResourceID, c.RealIP()), ticketID, submission.Status)
+	if err != nil {
+		return c.JSON(actionErrorStatus(err), actionErrorBody(err))
+	}
+	return c.JSON(http.StatusOK, w.ticketForRole(w.viewerRole(c), ticket))
+}
+
+// renders the create ticket form
+func (w *WebApp) handleNewTicketForm(c echo.Context) error {
+	if !w.Sc.SecretsAreLoaded() {
+		return c.Redirect(http.StatusSeeOther, "/secrets")
+	}
+	return c.Render(http.StatusOK, "createTicket.html", serverInfo{Version: w.serverParams.versionStr})
+}
+
+// creates a ticket from the submitted fields
+func (w *WebApp) handleCreateTicket(c echo.Context) error {
+	var submission newTicketSubmission
+	if err := c.Bind(&submission); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	ticketID, err := w.createTicket(newActionActor(requestSession(c), submission.ResourceID, c.RealIP()), submission.NewTicket)
+	if err != nil {
+		return c.JSON(actionErrorStatus(err), actionErrorBody(err))
+	}
+	return c.JSON(http.StatusCreated, map[string]int64{"id": ticketID})
+}
+
+// returns companies matching the q query parameter
+func (w *WebApp) handleSearchCompanies(c echo.Context) error {
+	name := strings.TrimSpace(c.QueryParam("q"))
+	if len(name) < 2 {
+		return c.JSON(http.StatusOK, []api.Company{})
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), actionTimeout)
+	defer cancel()
+	client, err := w.getApiClient(ctx)
+	if err != nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+	}
+	companies, err := client.SearchCompanies(ctx, name)
+	if err != nil {
+		return c.JSON(actionErrorStatus(err), actionErrorBody(err))
+	}
+	return c.JSON(http.StatusOK, companies)
+}
+
+// reads the ticket id path parameter and the action submission body
+func bindAction(c echo.Context) (int64, actionSubmission, error) {
+	var submission actionSubmission
+	ticketID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, submission, fmt.Errorf("Invalid ticket id")
+	}
+	if err := c.Bind(&submission); err != nil {
+		return 0, submission, fmt.Errorf("Invalid JSON")
+	}
+	return ticketID, submission, nil
+}
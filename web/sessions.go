@@ -0,0 +1,400 @@
+package web
+
+import (
+	"AutoTickets/auth"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// cookie holding the session token
+const sessionCookie = "autotickets_session"
+
+// echo context key of the request's auth.Session
+const sessionContextKey = "session"
+
+// how often feed clients are checked for expired sessions
+const endedSessionsInterval = time.Minute
+
+// routes served without a session. The favicons are shown on the sign in pages
+var publicRoutes = map[string]bool{
+	"/favicon.ico":         true,
+	"/favicon2.ico":        true,
+	"/login":               true,
+	"/logout":              true,
+	"/unlock":              true,
+	"/setup":               true,
+	oidcPath:               true,
+	oidcPath + "/callback": true,
+}
+
+// submitted login
+type loginSubmission struct {
+	Username string `json:"username" form:"username"`
+	Password string `json:"password" form:"password"`
+	Next     string `json:"next" form:"next"`
+}
+
+// submitted first admin, with the setup token printed at startup
+type setupSubmission struct {
+	Token    string `json:"token" form:"token"`
+	Username string `json:"username" form:"username"`
+	Password string `json:"password" form:"password"`
+}
+
+// submitted user, created or replaced by an admin
+type userSubmission struct {
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Role       string `json:"role"`
+	ResourceID int64  `json:"resourceID"`
+}
+
+// used to render login.html, logout.html and setup.html
+type loginInfo struct {
+	Version string
+	Next    string
+	// single sign-on is configured
+	Sso bool
+}
+
+// setup token
+// mutex-protected one-time token allowing the first admin to be created, empty once users exist
+type setupState struct {
+	sync.Mutex
+	token string
+}
+
+// creates a setup token if there are no users yet. With single sign-on, admins come from the provider
+func (w *WebApp) initSetup() {
+	if w.users.Count() > 0 || w.users.Locked() || w.oidc != nil {
+		return
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		panic(err)
+	}
+	w.setup.Lock()
+	defer w.setup.Unlock()
+	w.setup.token = hex.EncodeToString(raw)
+}
+
+// returns the setup token, empty if setup is done
+func (w *WebApp) setupToken() string {
+	w.setup.Lock()
+	defer w.setup.Unlock()
+	return w.setup.token
+}
+
+// returns the session of the request's cookie
+func (w *WebApp) sessionFromRequest(c echo.Context) (auth.Session, bool) {
+	cookie, err := c.Cookie(sessionCookie)
+	if err != nil {
+		return auth.Session{}, false
+	}
+	return w.sessions.Get(cookie.Value)
+}
+
+// returns the session set by requireSession
+func requestSession(c echo.Context) auth.Session {
+	session, _ := c.Get(sessionContextKey).(auth.Session)
+	return session
+}
+
+// middleware: requests to every non-public route need a session. Browsers are sent to the login page
+// (or setup page, while setup is pending), other clients get 401
+func (w *WebApp) requireSession(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if publicRoutes[c.Path()] {
+			return next(c)
+		}
+		if session, ok := w.sessionFromRequest(c); ok {
+			c.Set(sessionContextKey, session)
+			return next(c)
+		}
+
+		if w.setupToken() != "" {
+			if wantsHtml(c) {
+				return c.Redirect(http.StatusSeeOther, "/setup")
+			}
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "No users yet, create an admin at /setup"})
+		}
+		if wantsHtml(c) {
+			return c.Redirect(http.StatusSeeOther, "/login?next="+url.QueryEscape(c.Request().URL.RequestURI()))
+		}
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Login required"})
+	}
+}
+
+// middleware: only admins may use the route
+func requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !requestSession(c).Role.IsAdmin() {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Only admins can do this"})
+		}
+		return next(c)
+	}
+}
+
+// true if the request is a browser navigating to a page
+func wantsHtml(c echo.Context) bool {
+	return c.Request().Method == http.MethodGet && strings.Contains(c.Request().Header.Get(echo.HeaderAccept), echo.MIMETextHTML)
+}
+
+// returns next if it is a path on this server, otherwise "/"
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// starts a session for the user of session and sets its cookie
+func (w *WebApp) startSession(c echo.Context, session auth.Session) error {
+	token, session, err := w.sessions.Create(session)
+	if err != nil {
+		return err
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.Expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   c.Scheme() == "https",
+	})
+	return nil
+}
+
+// clears the session cookie
+func clearSessionCookie(c echo.Context) {
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   c.Scheme() == "https",
+	})
+}
+
+// renders the login page, the setup page while setup is pending, or the unlock page while users are locked
+func (w *WebApp) handleLoginPage(c echo.Context) error {
+	if w.setupToken() != "" {
+		return c.Redirect(http.StatusSeeOther, "/setup")
+	}
+	if w.users.Locked() {
+		return c.Render(http.StatusOK, "unlockSecrets.html", serverInfo{Version: w.serverParams.versionStr, UnlockPath: "/unlock"})
+	}
+	return c.Render(http.StatusOK, "login.html", loginInfo{
+		Version: w.serverParams.versionStr,
+		Next:    safeNext(c.QueryParam("next")),
+		Sso:     w.oidc != nil,
+	})
+}
+
+// checks the submitted credentials and starts a session, then redirects to next
+func (w *WebApp) handleLogin(c echo.Context) error {
+	var submission loginSubmission
+	if err := c.Bind(&submission); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	role, err := w.users.Authenticate(submission.Username, submission.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid username or password"})
+	}
+	if errors.Is(err, auth.ErrUsersLocked) {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Users are locked, unlock them with the API secrets password at /login"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	username := auth.NormalizeUsername(submission.Username)
+	if err := w.startSession(c, auth.Session{Username: username, DisplayName: username, Role: role}); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start session"})
+	}
+	return c.Redirect(http.StatusSeeOther, safeNext(submission.Next))
+}
+
+// opens the locked users file, and the API secrets if they are locked too, with the secrets password.
+// Public, since nobody can sign in with a local account until it succeeds
+func (w *WebApp) handleUnlock(c echo.Context) error {
+	var submission submittedSecrets
+	if err := c.Bind(&submission); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	if !w.users.Locked() {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Users are already unlocked"})
+	}
+	if err := w.users.Open([]byte(submission.Password)); err != nil {
+		if errors.Is(err, auth.ErrWrongUsersPassword) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Wrong password"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if !w.Sc.SecretsAreLoaded() && w.Sc.EncFilePresent() {
+		if err := w.Sc.DecryptSecrets([]byte(submission.Password), 1024); err != nil {
+			fmt.Println("Users unlocked, but the secrets file doesn't open with the same password:", err)
+		} else {
+			go w.connectAndPollApi(false)
+		}
+	}
+	return c.Redirect(http.StatusSeeOther, "/login")
+}
+
+// ends the session and renders the logout page. POST only, so a link or image elsewhere can't sign users out
+func (w *WebApp) handleLogout(c echo.Context) error {
+	if cookie, err := c.Cookie(sessionCookie); err == nil {
+		w.sessions.Delete(cookie.Value)
+		w.closeEndedSessionClients()
+	}
+	clearSessionCookie(c)
+	return c.Render(http.StatusOK, "logout.html", loginInfo{Version: w.serverParams.versionStr})
+}
+
+// renders the setup page while there are no users
+func (w *WebApp) handleSetupPage(c echo.Context) error {
+	if w.setupToken() == "" {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+	return c.Render(http.StatusOK, "setup.html", loginInfo{Version: w.serverParams.versionStr})
+}
+
+// creates the first admin if the setup token matches, then signs them in
+func (w *WebApp) handleSetup(c echo.Context) error {
+	var submission setupSubmission
+	if err := c.Bind(&submission); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+
+	w.setup.Lock()
+	defer w.setup.Unlock()
+	if w.setup.token == "" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Setup is already done"})
+	}
+	if subtle.ConstantTimeCompare([]byte(submission.Token), []byte(w.setup.token)) != 1 {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid setup token"})
+	}
+	if err := w.users.SetUser(submission.Username, submission.Password, auth.RoleAdmin, 0); err != nil {
+		return c.JSON(userErrorStatus(err), map[string]string{"error": err.Error()})
+	}
+	w.setup.token = ""
+	username := auth.NormalizeUsername(submission.Username)
+	if err := w.startSession(c, auth.Session{Username: username, DisplayName: username, Role: auth.RoleAdmin}); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start session"})
+	}
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
+// returns the signed in user, the name to show for them, and their role
+func (w *WebApp) handleViewer(c echo.Context) error {
+	session := requestSession(c)
+	return c.JSON(http.StatusOK, map[string]string{"username": session.Username, "displayName": session.DisplayName, "role": string(session.Role)})
+}
+
+// renders the user management page
+func (w *WebApp) handleUsersPage(c echo.Context) error {
+	return c.Render(http.StatusOK, "users.html", serverInfo{
+		Version:  w.serverParams.versionStr,
+		Username: requestSession(c).Username,
+	})
+}
+
+// returns every user and their role
+func (w *WebApp) handleListUsers(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string][]auth.User{"users": w.users.List()})
+}
+
+// creates a user, or replaces their role, resource and password (kept if empty), ending their sessions.
+// Admins can't change their own role, so at least one admin always remains
+func (w *WebApp) handleSetUser(c echo.Context) error {
+	var submission userSubmission
+	if err := c.Bind(&submission); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+	}
+	role, err := auth.ParseRole(submission.Role)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	username := auth.NormalizeUsername(submission.Username)
+	if username == requestSession(c).Username && role != auth.RoleAdmin {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "You can't remove your own admin role"})
+	}
+	if err := w.users.SetUser(username, submission.Password, role, submission.ResourceID); err != nil {
+		return c.JSON(userErrorStatus(err), map[string]string{"error": err.Error()})
+	}
+	w.sessions.DeleteUser(username)
+	w.closeEndedSessionClients()
+	return c.JSON(http.StatusOK, auth.User{Username: username, Role: role, ResourceID: submission.ResourceID})
+}
+
+// deletes a user and ends their sessions. Admins can't delete themselves
+func (w *WebApp) handleDeleteUser(c echo.Context) error {
+	username := auth.NormalizeUsername(c.Param("username"))
+	if username == requestSession(c).Username {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "You can't delete yourself"})
+	}
+	if err := w.users.DeleteUser(username); err != nil {
+		return c.JSON(userErrorStatus(err), map[string]string{"error": err.Error()})
+	}
+	w.sessions.DeleteUser(username)
+	w.closeEndedSessionClients()
+	return c.NoContent(http.StatusNoContent)
+}
+
+// closes every client whose session has ended: logged out, expired, or its user changed or deleted
+func (w *WebApp) closeEndedSessionClients() {
+	w.clients.Lock()
+	var ended []*feedClient
+	for client := range w.clients.clients {
+		if !w.sessions.Active(client.sessionID) {
+			ended = append(ended, client)
+		}
+	}
+	w.clients.Unlock()
+	for _, client := range ended {
+		w.clients.remove(client)
+	}
+}
+
+// periodically closes feed clients of expired sessions
+func (w *WebApp) periodicallyCloseEndedSessions() {
+	ticker := time.NewTicker(endedSessionsInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		w.closeEndedSessionClients()
+	}
+}
+
+// maps a user store error to an http status
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrInvalidUsername), errors.Is(err, auth.ErrWeakPassword), errors.Is(err, auth.ErrInvalidResource):
+		return http.StatusBadRequest
+	case errors.Is(err, auth.ErrUnknownUser):
+		return http.StatusNotFound
+	case errors.Is(err, auth.ErrUsersLocked):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// prints how to create the first admin, while there are no users
+func (w *WebApp) printSetupInstructions() {
+	if w.users.Locked() {
+		fmt.Printf("Dashboard users are locked. Unlock them at http://localhost:%d/login with the API secrets password\n", w.serverParams.port)
+	}
+	if token := w.setupToken(); token != "" {
+		fmt.Printf("No dashboard users yet. Create the first admin at http://localhost:%d/setup with setup token %s\n", w.serverParams.port, token)
+	}
+}
@@ -0,0 +1,266 @@
+package web
+
+import (
+	"AutoTickets/auth"
+	"AutoTickets/tickets"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sends a request to the app, with cookie if set, and returns the response
+func sendTestRequest(w *WebApp, method, target, body, cookie string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if cookie != "" {
+		req.Header.Set("Cookie", cookie)
+	}
+	rec := httptest.NewRecorder()
+	w.E.ServeHTTP(rec, req)
+	return rec
+}
+
+// returns the Cookie header for the session cookie a response sets
+func responseSessionCookie(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == sessionCookie && cookie.Value != "" {
+			if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+				t.Errorf("session cookie should be HttpOnly and SameSite=Lax: %+v", cookie)
+			}
+			return (&http.Cookie{Name: cookie.Name, Value: cookie.Value}).String()
+		}
+	}
+	t.Fatalf("no session cookie set, status %d %s", rec.Code, rec.Body)
+	return ""
+}
+
+func TestRoutesRequireSession(t *testing.T) {
+	w, srv := newTestApp(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	w.E.ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/setup" {
+		t.Errorf("expected browsers to be sent to setup, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	for _, target := range []string{"/api/v1/tickets", "/events", "/viewer", "/secrets", "/resources"} {
+		if rec := sendTestRequest(w, http.MethodGet, target, "", ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %d", target, rec.Code)
+		}
+	}
+	if rec := sendTestRequest(w, http.MethodGet, "/viewer", "", sessionCookie+"=forged"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("forged session: expected 401, got %d", rec.Code)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/wsTickets", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected websocket upgrade without a session to be refused with 401, got %v", err)
+	}
+}
+
+func TestStaticFilesRequireSession(t *testing.T) {
+	t.Chdir(t.TempDir())
+	os.Mkdir("static", 0700)
+	for _, name := range []string{"favicon.ico", "logo.png"} {
+		if err := os.WriteFile("static/"+name, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, _ := newTestApp(t)
+
+	if rec := sendTestRequest(w, http.MethodGet, "/favicon.ico", "", ""); rec.Code != http.StatusOK || rec.Body.String() != "favicon.ico" {
+		t.Errorf("expected the favicon to be public, got %d", rec.Code)
+	}
+	if rec := sendTestRequest(w, http.MethodGet, "/logo.png", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected static files to need a session, got %d", rec.Code)
+	}
+	rec := sendTestRequest(w, http.MethodGet, "/logo.png", "", testSessionCookie(t, w, auth.RoleStandard))
+	if rec.Code != http.StatusOK || rec.Body.String() != "logo.png" {
+		t.Errorf("expected static files to be served with a session, got %d", rec.Code)
+	}
+}
+
+func TestSetupAndLogin(t *testing.T) {
+	w, _ := newTestApp(t)
+	token := w.setupToken()
+	if token == "" {
+		t.Fatal("expected a setup token while there are no users")
+	}
+
+	if rec := sendTestRequest(w, http.MethodGet, "/setup", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("setup page: expected 200, got %d", rec.Code)
+	}
+	if rec := sendTestRequest(w, http.MethodPost, "/setup", `{"token":"wrong","username":"admin","password":"correct horse"}`, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: expected 401, got %d", rec.Code)
+	}
+	rec := sendTestRequest(w, http.MethodPost, "/setup", `{"token":"`+token+`","username":"Admin","password":"correct horse"}`, "")
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("setup: expected 303, got %d %s", rec.Code, rec.Body)
+	}
+	adminCookie := responseSessionCookie(t, rec)
+	if rec := sendTestRequest(w, http.MethodPost, "/setup", `{"token":"`+token+`","username":"eve","password":"correct horse"}`, ""); rec.Code != http.StatusConflict {
+		t.Errorf("second setup: expected 409, got %d", rec.Code)
+	}
+
+	for _, page := range []string{"/login", "/admin/users"} {
+		if rec := sendTestRequest(w, http.MethodGet, page, "", adminCookie); rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d %s", page, rec.Code, rec.Body)
+		}
+	}
+
+	var viewer map[string]string
+	rec = sendTestRequest(w, http.MethodGet, "/viewer", "", adminCookie)
+	json.Unmarshal(rec.Body.Bytes(), &viewer)
+	if viewer["username"] != "admin" || viewer["role"] != string(auth.RoleAdmin) {
+		t.Errorf("unexpected viewer %v", viewer)
+	}
+
+	if rec := sendTestRequest(w, http.MethodPost, "/login", `{"username":"admin","password":"wrong password"}`, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: expected 401, got %d", rec.Code)
+	}
+	for next, want := range map[string]string{"/api/v1/status": "/api/v1/status", "//evil.example": "/", "https://evil.example": "/"} {
+		rec := sendTestRequest(w, http.MethodPost, "/login", `{"username":"admin","password":"correct horse","next":"`+next+`"}`, "")
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != want {
+			t.Errorf("next %q: expected redirect to %q, got %d %q", next, want, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+
+	rec = sendTestRequest(w, http.MethodPost, "/login", `{"username":"admin","password":"correct horse"}`, "")
+	loginCookie := responseSessionCookie(t, rec)
+	if rec := sendTestRequest(w, http.MethodGet, "/logout", "", loginCookie); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("logout by GET: expected 405, got %d", rec.Code)
+	}
+	if rec := sendTestRequest(w, http.MethodGet, "/viewer", "", loginCookie); rec.Code != http.StatusOK {
+		t.Errorf("expected session to survive logout by GET, got %d", rec.Code)
+	}
+	if rec := sendTestRequest(w, http.MethodPost, "/logout", "", loginCookie); rec.Code != http.StatusOK {
+		t.Errorf("logout page: expected 200, got %d", rec.Code)
+	}
+	if rec := sendTestRequest(w, http.MethodGet, "/viewer", "", loginCookie); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected session to end at logout, got %d", rec.Code)
+	}
+}
+
+func TestAdminManagesUsers(t *testing.T) {
+	w, _ := newTestApp(t)
+	admin := testSessionCookie(t, w, auth.RoleAdmin)
+	standard := testSessionCookie(t, w, auth.RoleStandard)
+
+	user := `{"username":"bob","password":"battery staple","role":"privileged"}`
+	if rec := sendTestRequest(w, http.MethodPost, "/users", user, standard); rec.Code != http.StatusForbidden {
+		t.Errorf("standard user: expected 403, got %d", rec.Code)
+	}
+	if rec := sendTestRequest(w, http.MethodPost, "/users", user, admin); rec.Code != http.StatusOK {
+		t.Fatalf("expected user to be created, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := sendTestRequest(w, http.MethodPost, "/users", `{"username":"tester","password":"battery staple","role":"standard"}`, admin); rec.Code != http.StatusBadRequest {
+		t.Errorf("self demotion: expected 400, got %d", rec.Code)
+	}
+	if rec := sendTestRequest(w, http.MethodDelete, "/users/tester", "", admin); rec.Code != http.StatusBadRequest {
+		t.Errorf("self deletion: expected 400, got %d", rec.Code)
+	}
+
+	// roles change without a new password, and are listed
+	if rec := sendTestRequest(w, http.MethodPost, "/users", `{"username":"bob","role":"standard"}`, admin); rec.Code != http.StatusOK {
+		t.Fatalf("expected bob's role to change, got %d %s", rec.Code, rec.Body)
+	}
+	var list struct{ Users []auth.User }
+	json.Unmarshal(sendTestRequest(w, http.MethodGet, "/users", "", admin).Body.Bytes(), &list)
+	if len(list.Users) != 1 || list.Users[0] != (auth.User{Username: "bob", Role: auth.RoleStandard}) {
+		t.Errorf("unexpected users %+v", list.Users)
+	}
+
+	// bob's sessions end when he is deleted
+	bob := responseSessionCookie(t, sendTestRequest(w, http.MethodPost, "/login", `{"username":"bob","password":"battery staple"}`, ""))
+	if rec := sendTestRequest(w, http.MethodDelete, "/users/bob", "", admin); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected bob to be deleted, got %d", rec.Code)
+	}
+	if rec := sendTestRequest(w, http.MethodGet, "/viewer", "", bob); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected deleted user's session to end, got %d", rec.Code)
+	}
+}
+
+func TestRoleControlsRedaction(t *testing.T) {
+	w, _ := newTestApp(t)
+	ts := []tickets.AutotaskTicket{{ID: 1, Title: "Terminate user account"}}
+	w.Tc.SetTickets(&ts)
+
+	for role, redacted := range map[auth.Role]bool{auth.RoleStandard: true, auth.RolePrivileged: false, auth.RoleAdmin: false} {
+		rec := sendTestRequest(w, http.MethodGet, "/api/v1/tickets/1", "", testSessionCookie(t, w, role))
+		var ticket tickets.AutotaskTicket
+		json.Unmarshal(rec.Body.Bytes(), &ticket)
+		if ticket.Redacted != redacted {
+			t.Errorf("%s: expected redacted=%t, got %+v", role, redacted, ticket)
+		}
+	}
+}
+
+func TestLogoutClosesFeedConnections(t *testing.T) {
+	w, srv := newTestApp(t)
+	cookie := testSessionCookie(t, w, auth.RoleStandard)
+	conn := dialTestSession(t, srv, cookie)
+
+	if rec := sendTestRequest(w, http.MethodPost, "/logout", "", cookie); rec.Code != http.StatusOK {
+		t.Fatalf("logout: expected 200, got %d", rec.Code)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatalf("expected the server to close the connection, got %v", err)
+			}
+			break
+		}
+	}
+	w.clients.Lock()
+	defer w.clients.Unlock()
+	if len(w.clients.clients) != 0 {
+		t.Errorf("expected no feed clients after logout, got %d", len(w.clients.clients))
+	}
+}
+
+// users sealed under the secrets password stay locked after a restart, until someone enters it
+func TestUnlockUsers(t *testing.T) {
+	dir := t.TempDir()
+	users, _ := auth.LoadUsers(dir + "/secrets.gob.users")
+	users.SetUser("admin", "correct horse", auth.RoleAdmin, 0)
+	if err := users.Open([]byte("secrets password")); err != nil {
+		t.Fatal(err)
+	}
+	w, _ := newTestAppIn(t, dir, nil)
+
+	if w.setupToken() != "" {
+		t.Error("expected no setup token while users are locked")
+	}
+	if rec := sendTestRequest(w, http.MethodGet, "/login", "", ""); !strings.Contains(rec.Body.String(), "Unlock") {
+		t.Errorf("expected the login page to ask for the secrets password, got %d", rec.Code)
+	}
+	login := `{"username":"admin","password":"correct horse"}`
+	if rec := sendTestRequest(w, http.MethodPost, "/login", login, ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("locked users: expected 503, got %d", rec.Code)
+	}
+	if rec := sendTestRequest(w, http.MethodPost, "/unlock", `{"password":"wrong password"}`, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: expected 401, got %d", rec.Code)
+	}
+	if rec := sendTestRequest(w, http.MethodPost, "/unlock", `{"password":"secrets password"}`, ""); rec.Code != http.StatusSeeOther {
+		t.Fatalf("expected users to unlock, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := sendTestRequest(w, http.MethodPost, "/login", login, ""); rec.Code != http.StatusSeeOther {
+		t.Errorf("expected admin to sign in once unlocked, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := sendTestRequest(w, http.MethodPost, "/unlock", `{"password":"secrets password"}`, ""); rec.Code != http.StatusConflict {
+		t.Errorf("already unlocked: expected 409, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,26 @@
+package web
+
+import (
+	"AutoTickets/redact"
+	"AutoTickets/tickets"
+
+	"github.com/labstack/echo/v4"
+)
+
+// returns the redaction role of the signed in viewer: privileged and admin users see sensitive tickets in full
+func (w *WebApp) viewerRole(c echo.Context) redact.Role {
+	if requestSession(c).Role.SeesSensitive() {
+		return redact.RolePrivileged
+	}
+	return redact.RoleStandard
+}
+
+// returns ts as the viewer with role may see them
+func (w *WebApp) ticketsForRole(role redact.Role, ts []tickets.AutotaskTicket) []tickets.AutotaskTicket {
+	return w.redaction.ForRole(role, ts)
+}
+
+// returns a single ticket as the viewer with role may see it
+func (w *WebApp) ticketForRole(role redact.Role, t tickets.AutotaskTicket) tickets.AutotaskTicket {
+	return w.ticketsForRole(role, []tickets.AutotaskTicket{t})[0]
+}
@@ -2,9 +2,14 @@ package web
 
 import (
 	"AutoTickets/api"
+	"AutoTickets/audit"
+	"AutoTickets/auth"
+	"AutoTickets/redact"
 	"AutoTickets/secrets"
 	"AutoTickets/tickets"
+	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -12,7 +17,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
@@ -22,11 +26,35 @@ type WebApp struct {
 	E            *echo.Echo
 	Sc           secrets.SecretsCollection
 	Tc           tickets.TicketCollection
-	wsClients    wsClients
+	clients      feedClients
+	feed         ticketFeed
 	serverParams serverParams
 	lastGoodApi  apiStatus
+	apiConn      apiConn
+	metadata     *api.Metadata
+	resources    *api.ResourceCache
+	companies    *api.CompanyCache
+	pollState    pollState
+	thresholds   *api.ThresholdTracker
+	pollInterval pollInterval
+	ticketWrites sync.Mutex
+	auditLog     audit.Log
+	redaction    *redact.Policy
+	users        *auth.Users
+	sessions     *auth.Sessions
+	oidc         *auth.OIDC
+	setup        setupState
 }
 
+// upper bound on a single poll, including retries of its requests
+const pollTimeout = 5 * time.Minute
+
+// minimum time between polls requested by clients
+const refreshMinInterval = 10 * time.Second
+
+// returned when a client asks for a poll outside active hours
+var errOutOfHours = errors.New("API is not polled outside active hours")
+
 // embeds html files in compiled executable
 //
 //go:embed templates/*.html
@@ -41,29 +69,52 @@ func NewWebApp(
 	verboseApi bool,
 	apiStart int,
 	apiEnd int,
+	apiUrl string,
+	deltaPoll bool,
+	resyncSecs int,
+	auditLogPath string,
+	redaction *redact.Policy,
+	users *auth.Users,
+	sessionHours int,
+	oidc *auth.OIDC,
 	versionStr string) (w *WebApp) {
 
 	ticketsSlice := make([]tickets.AutotaskTicket, 0)
 
 	w = &WebApp{
-		E:         echo.New(),
-		Sc:        secrets.SecretsCollection{FilePath: saveFilePath},
-		Tc:        tickets.TicketCollection{Tickets: &ticketsSlice},
-		wsClients: wsClients{clients: make(map[*websocket.Conn]bool)},
+		E:          echo.New(),
+		Sc:         secrets.SecretsCollection{FilePath: saveFilePath},
+		Tc:         tickets.TicketCollection{Tickets: &ticketsSlice},
+		clients:    feedClients{clients: make(map[*feedClient]bool)},
+		metadata:   api.NewMetadata(api.DefaultMetadataRefresh),
+		resources:  api.NewResourceCache(api.DefaultResourceTtl),
+		companies:  api.NewCompanyCache(api.DefaultCompanyTtl),
+		thresholds: api.NewThresholdTracker(),
+		auditLog:   audit.Log{FilePath: auditLogPath},
+		redaction:  redaction,
+		users:      users,
+		sessions:   auth.NewSessions(time.Duration(sessionHours) * time.Hour),
+		oidc:       oidc,
 		serverParams: serverParams{
 			apiStartHour: apiStart,
 			apiEndHour:   apiEnd,
 			verboseApi:   verboseApi,
 			pollRate:     pollRate,
 			port:         port,
+			apiUrl:       apiUrl,
+			deltaPoll:    deltaPoll,
+			resyncSecs:   resyncSecs,
 			versionStr:   versionStr,
 		},
 	}
+	w.initSetup()
 
 	if logHttp {
 		w.E.Use(middleware.Logger())
 	}
 	w.E.Use(middleware.Recover())
+	w.E.Use(w.requireSession)
+	// static files are only served once requireSession has let the request through
 	w.E.Use(middleware.StaticWithConfig(middleware.StaticConfig{
 		Root: "static",
 	}))
@@ -72,22 +123,45 @@ func NewWebApp(
 		templates: template.Must(template.ParseFS(templateFS, "templates/*.html")),
 	}
 
+	w.E.File("/favicon.ico", "static/favicon.ico")
+	w.E.File("/favicon2.ico", "static/favicon2.ico")
+	w.E.GET("/login", w.handleLoginPage)
+	w.E.POST("/login", w.handleLogin)
+	w.E.POST("/unlock", w.handleUnlock)
+	w.E.GET(oidcPath, w.handleOidcLogin)
+	w.E.GET(oidcPath+"/callback", w.handleOidcCallback)
+	w.E.POST("/logout", w.handleLogout)
+	w.E.GET("/setup", w.handleSetupPage)
+	w.E.POST("/setup", w.handleSetup)
 	w.E.GET("/", w.handleRoot)
-	w.E.GET("/secrets", w.handleSecrets)
-	w.E.POST("/submitSecrets", w.handleReceiveSecrets)
-	// w.E.GET("/rscIdCount", func(c echo.Context) error {
-	// 	w.RLock()
-	// 	defer w.RUnlock()
-	// 	return c.JSON(http.StatusOK, w.getRescIdCount())
-	// })
+	w.E.GET("/secrets", w.handleSecrets, requireAdmin)
+	w.E.POST("/submitSecrets", w.handleReceiveSecrets, requireAdmin)
+	w.E.GET("/admin/users", w.handleUsersPage, requireAdmin)
+	w.E.GET("/users", w.handleListUsers, requireAdmin)
+	w.E.POST("/users", w.handleSetUser, requireAdmin)
+	w.E.DELETE("/users/:username", w.handleDeleteUser, requireAdmin)
+	w.E.GET("/rscIdCount", w.handleRescIdCount)
+	w.E.GET("/resources", w.handleResources)
+	w.E.GET("/picklists/:field", w.handlePicklist)
+	w.E.GET("/companies", w.handleSearchCompanies)
+	w.E.GET("/tickets/new", w.handleNewTicketForm)
+	w.E.POST("/tickets", w.handleCreateTicket)
+	w.E.POST("/tickets/:id/claim", w.handleClaimTicket)
+	w.E.POST("/tickets/:id/notes", w.handleAddTicketNote)
+	w.E.POST("/tickets/:id/status", w.handleSetTicketStatus)
+	w.E.GET("/viewer", w.handleViewer)
 	w.E.GET("/wsTickets", w.handleWsTickets)
+	w.E.GET("/events", w.handleEvents)
+	w.registerApiV1()
 	return w
 }
 
 // Starts serving clients and periodically polling API / updating websock clients
 func (w *WebApp) Start() {
+	w.printSetupInstructions()
 	go w.periodicallyPollApi()
 	go w.periodicallyBroadcastStatus()
+	go w.periodicallyCloseEndedSessions()
 	portStr := ":" + strconv.Itoa(w.serverParams.port)
 	if err := w.E.Start(portStr); err != nil {
 		fmt.Println("Error starting server:", err)
@@ -95,11 +169,16 @@ func (w *WebApp) Start() {
 }
 
 // periodically poll API and handle resulting data: update stored tickets and broadcast to websocket clients.
+// The interval starts at pollRate and is stretched while API threshold usage is high
 func (w *WebApp) periodicallyPollApi() {
-	ticker := time.NewTicker(time.Duration(w.serverParams.pollRate) * time.Second)
-	defer ticker.Stop()
+	basePollRate := time.Duration(w.serverParams.pollRate) * time.Second
+	w.pollInterval.set(basePollRate)
+	w.pollInterval.schedule(basePollRate)
+	timer := time.NewTimer(basePollRate)
+	defer timer.Stop()
+	lastHealth := w.apiConn.health()
 
-	for range ticker.C {
+	for range timer.C {
 		if !w.serverParams.getActive() {
 			if w.serverParams.verboseApi {
 				timeStamp := time.Now().Format("15:04 Jan 2")
@@ -113,6 +192,75 @@ func (w *WebApp) periodicallyPollApi() {
 				w.E.Logger.Error("error polling api:", err)
 			}
 		}
+
+		interval := w.thresholds.PollInterval(basePollRate)
+		intervalChanged := w.pollInterval.set(interval)
+		if intervalChanged {
+			usage := w.thresholds.Usage()
+			fmt.Printf("\nAPI usage %d/%d, poll interval now %v\n", usage.Count, usage.Limit, interval)
+		}
+		health := w.apiConn.health()
+		if intervalChanged || health != lastHealth {
+			lastHealth = health
+			go w.broadcastStatus()
+		}
+		w.pollInterval.schedule(interval)
+		timer.Reset(interval)
+	}
+}
+
+// creates the api client from loaded secrets. The base url comes from the apiurl override if set,
+// otherwise from the zone cache next to the secrets file, otherwise from zone discovery (which is then cached).
+// rediscover skips the zone cache, used when new secrets are submitted
+func (w *WebApp) connectApi(ctx context.Context, rediscover bool) (*api.Client, error) {
+	if !w.Sc.SecretsAreLoaded() {
+		return nil, fmt.Errorf("secrets not loaded, cannot connect to API")
+	}
+	integrationCode, secret, username := w.Sc.GetSecrets()
+
+	baseUrl := w.serverParams.apiUrl
+	if baseUrl == "" && !rediscover {
+		baseUrl = w.Sc.LoadZoneUrl()
+	}
+	if baseUrl == "" {
+		zoneUrl, err := api.ResolveZone(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+		if err := w.Sc.SaveZoneUrl(zoneUrl); err != nil {
+			fmt.Println("Error caching API zone:", err)
+		}
+		baseUrl = zoneUrl
+	}
+	if w.serverParams.verboseApi {
+		fmt.Printf("\n  using API base url '%v'\n", baseUrl)
+	}
+
+	client := api.NewClient(baseUrl, integrationCode, secret, username)
+	client.SetThresholdTracker(w.thresholds)
+	w.apiConn.set(client)
+	w.pollState.reset()
+	return client, nil
+}
+
+// returns the api client, connecting first if needed
+func (w *WebApp) getApiClient(ctx context.Context) (*api.Client, error) {
+	if client := w.apiConn.get(); client != nil {
+		return client, nil
+	}
+	return w.connectApi(ctx, false)
+}
+
+// connects to the API and polls it. Run once secrets are loaded
+func (w *WebApp) connectAndPollApi(rediscover bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), pollTimeout)
+	defer cancel()
+	if _, err := w.connectApi(ctx, rediscover); err != nil {
+		fmt.Println("Error connecting to API:", err)
+		return
+	}
+	if err := w.pollApi(); err != nil {
+		w.E.Logger.Error("error polling api:", err)
 	}
 }
 
@@ -122,20 +270,59 @@ func (w *WebApp) pollApi() error {
 		fmt.Println("Secrets not loaded, cannot poll API")
 		return fmt.Errorf("secrets not loaded, cannot poll API")
 	}
-	freshTickets, err := api.GetOpenTickets(w.Sc.GetSecrets())
+	ctx, cancel := context.WithTimeout(context.Background(), pollTimeout)
+	defer cancel()
 
+	client, err := w.getApiClient(ctx)
 	if err != nil {
-		fmt.Println("Error fetching tickets:", err)
+		fmt.Println("Error connecting to API:", err)
 		return err
 	}
-	// Set last successful API check time
-	w.lastGoodApi.setGood()
 
-	if w.serverParams.verboseApi {
-		timeStamp := time.Now().Format("15:04 Jan 2")
-		fmt.Printf("\n[%v] fresh tickets obtained. Fresh open ticket count: %v", timeStamp, len(freshTickets))
+	if err := w.thresholds.Refresh(ctx, client); err != nil {
+		fmt.Println("Error reading API threshold:", err)
+	}
+
+	// polls are serialized so delta merges apply in order
+	w.pollState.Lock()
+	defer w.pollState.Unlock()
+
+	pollStart := time.Now()
+	fullSync := w.pollState.needsFullSync(w.serverParams.deltaPoll, time.Duration(w.serverParams.resyncSecs)*time.Second)
+	if fullSync {
+		freshTickets, err := client.GetOpenTickets(ctx)
+		if err != nil {
+			fmt.Println("Error fetching tickets:", err)
+			return err
+		}
+		// Set last successful API check time
+		w.lastGoodApi.setGood()
+
+		w.enrichTickets(ctx, client, freshTickets)
+
+		if w.serverParams.verboseApi {
+			timeStamp := time.Now().Format("15:04 Jan 2")
+			fmt.Printf("\n[%v] fresh tickets obtained. Fresh open ticket count: %v", timeStamp, len(freshTickets))
+		}
+		w.Tc.SetTickets(&freshTickets)
+	} else {
+		changedTickets, err := client.GetTicketsChangedSince(ctx, w.pollState.deltaSince())
+		if err != nil {
+			fmt.Println("Error fetching changed tickets:", err)
+			return err
+		}
+		w.lastGoodApi.setGood()
+
+		w.enrichTickets(ctx, client, changedTickets)
+
+		if w.serverParams.verboseApi {
+			timeStamp := time.Now().Format("15:04 Jan 2")
+			fmt.Printf("\n[%v] changed tickets obtained. Changed ticket count: %v", timeStamp, len(changedTickets))
+		}
+		w.Tc.MergeTickets(changedTickets)
 	}
-	w.Tc.SetTickets(&freshTickets)
+	w.pollState.setPolled(pollStart, fullSync)
+
 	if w.serverParams.verboseApi {
 		printHash := ""
 		currentHash := w.Tc.GetCurrentHash()
@@ -156,6 +343,51 @@ func (w *WebApp) pollApi() error {
 	return nil
 }
 
+// polls the API now, unless a poll started less than refreshMinInterval ago
+func (w *WebApp) refreshTickets() error {
+	if !w.serverParams.getActive() {
+		return errOutOfHours
+	}
+	if time.Since(w.pollState.lastPolled()) < refreshMinInterval {
+		return nil
+	}
+	return w.pollApi()
+}
+
+// resolves codes and ids on ts to human-readable labels and names. Tickets are stored unredacted;
+// the redaction policy is applied per viewer when they are sent. Lookup failures are logged; tickets are still usable without labels
+func (w *WebApp) enrichTickets(ctx context.Context, client *api.Client, ts []tickets.AutotaskTicket) {
+	if err := w.metadata.Refresh(ctx, client); err != nil {
+		fmt.Println("Error refreshing ticket metadata:", err)
+	}
+	w.metadata.ApplyLabels(ts)
+	if err := w.resources.RefreshActive(ctx, client); err != nil {
+		fmt.Println("Error refreshing active resources:", err)
+	}
+	if err := w.resources.ApplyNames(ctx, client, ts); err != nil {
+		fmt.Println("Error resolving ticket resources:", err)
+	}
+	if err := w.companies.ApplyNames(ctx, client, ts); err != nil {
+		fmt.Println("Error resolving ticket companies / contacts:", err)
+	}
+}
+
+// counts open tickets per assigned resource, keyed by name (or id if the name is not resolved)
+func (w *WebApp) getRescIdCount() map[string]int {
+	rescIdCount := make(map[string]int)
+	for _, ticket := range w.Tc.GetTickets() {
+		if ticket.AssignedResourceID == "" {
+			continue
+		}
+		if ticket.AssignedResourceName != "" {
+			rescIdCount[ticket.AssignedResourceName]++
+		} else {
+			rescIdCount[ticket.AssignedResourceID]++
+		}
+	}
+	return rescIdCount
+}
+
 //  helper types
 
 // server params
@@ -167,6 +399,9 @@ type serverParams struct {
 	verboseApi   bool
 	apiStartHour int
 	apiEndHour   int
+	apiUrl       string
+	deltaPoll    bool
+	resyncSecs   int
 	versionStr   string
 }
 
@@ -199,6 +434,120 @@ func (as *apiStatus) getTime() time.Time {
 	return as.time
 }
 
+// poll state
+// serializes polls and tracks when the last (full) poll started, for delta polling
+type pollState struct {
+	sync.Mutex
+	lastPoll     time.Time
+	lastFullSync time.Time
+}
+
+// overlap subtracted from the last poll time when asking for changes,
+// covers clock skew between this server and Autotask
+const deltaOverlap = 2 * time.Minute
+
+// true if the next poll must download every open ticket: delta polling is off,
+// no full sync has happened yet, or the last one is older than resync. Caller holds the lock
+func (ps *pollState) needsFullSync(deltaPoll bool, resync time.Duration) bool {
+	return !deltaPoll || ps.lastFullSync.IsZero() || time.Since(ps.lastFullSync) >= resync
+}
+
+// time changes should be requested from. Caller holds the lock
+func (ps *pollState) deltaSince() time.Time {
+	return ps.lastPoll.Add(-deltaOverlap)
+}
+
+// time the last successful poll started
+func (ps *pollState) lastPolled() time.Time {
+	ps.Lock()
+	defer ps.Unlock()
+	return ps.lastPoll
+}
+
+// records a successful poll that started at start. Caller holds the lock
+func (ps *pollState) setPolled(start time.Time, fullSync bool) {
+	ps.lastPoll = start
+	if fullSync {
+		ps.lastFullSync = start
+	}
+}
+
+// forces a full sync on the next poll
+func (ps *pollState) reset() {
+	ps.Lock()
+	defer ps.Unlock()
+	ps.lastPoll = time.Time{}
+	ps.lastFullSync = time.Time{}
+}
+
+// poll interval
+// mutex-protected current poll interval, and when the next poll is due
+type pollInterval struct {
+	sync.RWMutex
+	interval time.Duration
+	next     time.Time
+}
+
+// sets the interval, returns true if it changed
+func (pi *pollInterval) set(interval time.Duration) bool {
+	pi.Lock()
+	defer pi.Unlock()
+	changed := pi.interval != interval
+	pi.interval = interval
+	return changed
+}
+
+// gets the current interval
+func (pi *pollInterval) get() time.Duration {
+	pi.RLock()
+	defer pi.RUnlock()
+	return pi.interval
+}
+
+// records that the next poll is due after wait
+func (pi *pollInterval) schedule(wait time.Duration) {
+	pi.Lock()
+	defer pi.Unlock()
+	pi.next = time.Now().Add(wait)
+}
+
+// gets when the next poll is due, zero before polling starts
+func (pi *pollInterval) nextPoll() time.Time {
+	pi.RLock()
+	defer pi.RUnlock()
+	return pi.next
+}
+
+// api connection
+// mutex-protected api client, set once secrets are loaded and the zone is known
+type apiConn struct {
+	sync.RWMutex
+	client *api.Client
+}
+
+// sets the api client
+func (ac *apiConn) set(client *api.Client) {
+	ac.Lock()
+	defer ac.Unlock()
+	ac.client = client
+}
+
+// returns health of the api client; a client that is not yet connected reports as healthy
+func (ac *apiConn) health() api.Health {
+	client := ac.get()
+	if client == nil {
+		return api.Health{BreakerState: api.BreakerClosed}
+	}
+	return client.Health()
+}
+
+// gets the api client, nil if not yet connected
+func (ac *apiConn) get() *api.Client {
+	ac.RLock()
+	defer ac.RUnlock()
+	return ac.client
+}
+
 // submitted secrets
 // used to handle secrets submitted by user
 type submittedSecrets struct {
@@ -208,6 +557,24 @@ type submittedSecrets struct {
 	Password        string `json:"password"`
 }
 
+// action submission
+// ticket action submitted by a user. ResourceID is the acting resource;
+// RoleID 0 claims under the resource's default role
+type actionSubmission struct {
+	ResourceID  int64  `json:"resourceID"`
+	RoleID      int64  `json:"roleID"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Status      int    `json:"status"`
+}
+
+// new ticket submission
+// ticket to create, and the resource creating it
+type newTicketSubmission struct {
+	ResourceID int64 `json:"resourceID"`
+	api.NewTicket
+}
+
 // templating
 // used for http templating
 type Template struct {
@@ -218,13 +585,3 @@ type Template struct {
 func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
 	return t.templates.ExecuteTemplate(w, name, data)
 }
-
-// func (w *WebApp) getRescIdCount() map[string]int {
-// 	rescIdCount := make(map[string]int)
-// 	for _, ticket := range *w.Tc {
-// 		if ticket.AssignedResourceID != "" {
-// 			rescIdCount[ticket.AssignedResourceID]++
-// 		}
-// 	}
-// 	return rescIdCount
-// }
@@ -0,0 +1,37 @@
+package web
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollStateFullSync(t *testing.T) {
+	resync := 10 * time.Minute
+	var ps pollState
+	if !ps.needsFullSync(true, resync) {
+		t.Error("the first poll should be a full sync")
+	}
+	ps.setPolled(time.Now(), true)
+	if ps.needsFullSync(true, resync) {
+		t.Error("a delta poll should follow a recent full sync")
+	}
+	if !ps.needsFullSync(false, resync) {
+		t.Error("every poll should be a full sync with delta polling off")
+	}
+
+	// delta polls don't postpone the resync
+	ps.setPolled(time.Now().Add(-resync), true)
+	ps.setPolled(time.Now(), false)
+	if !ps.needsFullSync(true, resync) {
+		t.Error("expected a full sync once resync has passed since the last one")
+	}
+	if since := ps.deltaSince(); time.Until(since) > -deltaOverlap+time.Second {
+		t.Errorf("expected changes to be requested from before the last poll, got %v", since)
+	}
+
+	ps.setPolled(time.Now(), true)
+	ps.reset()
+	if !ps.needsFullSync(true, resync) {
+		t.Error("expected a full sync after a reset, e.g. new secrets")
+	}
+}
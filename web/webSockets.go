@@ -1,122 +1,163 @@
 package web
 
 import (
-	"net/http"
-	"sync"
+	"AutoTickets/auth"
+	"AutoTickets/protocol"
+	"AutoTickets/tickets"
+	"errors"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
 )
 
-// used to thread-safely manage several websocket connections
-type wsClients struct {
-	sync.Mutex
-	clients map[*websocket.Conn]bool
-}
+// time a new connection has to send its hello
+const helloTimeout = 10 * time.Second
+
+// returned to websocket clients sending messages this server does not understand
+var (
+	errUnexpectedVersion = errors.New("message version does not match the negotiated version")
+	errUnknownMessage    = errors.New("unknown message type")
+)
 
 // WebSocket handler for new connections
 func (w *WebApp) handleWsTickets(c echo.Context) error {
-	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
-	}
+	// the session cookie authenticates the upgrade, so the default origin check is kept:
+	// pages on other sites can't open a connection with the viewer's session
+	upgrader := websocket.Upgrader{}
+	role := w.viewerRole(c)
+	session := requestSession(c)
 	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
 	if err != nil {
 		return err
 	}
-	w.wsClients.Lock()
-	w.wsClients.clients[conn] = true
-	w.wsClients.Unlock()
-
-	sm := statusMessage{
-		Type:         "status",
-		LastApiCheck: w.lastGoodApi.getTime().Format(time.RFC3339),
-		IsActive:     w.serverParams.getActive(),
+	version, ok := handshake(conn)
+	if !ok {
+		conn.Close()
+		return nil
 	}
+	client := &wsClient{feedClient: newFeedClient(role, session.ID, version, conn.RemoteAddr().String()), conn: conn}
+	go client.writePump()
 
-	// send ticket and status message. If both succeed, listen for incoming messages
-	// if incoming message has error, delete client from list and close connection
-	if w.sendTicketMessage(conn) && w.sendStatusMessage(conn, sm) {
-		go func() {
-			for {
-				_, _, err := conn.ReadMessage()
-				if err != nil {
-					w.wsClients.Lock()
-					delete(w.wsClients.clients, conn)
-					w.wsClients.Unlock()
-					conn.Close()
-					break
-				}
-			}
-		}()
-	}
+	// new clients see unassigned tickets until they subscribe to something else
+	w.addClient(client.feedClient, tickets.Filter{View: tickets.ViewUnassigned}, "")
+
+	// listen for incoming messages. If reading fails, delete client from list and close connection
+	go func() {
+		client.readPump(func(data []byte) {
+			w.handleWsMessage(client.feedClient, session, data)
+		})
+		w.clients.remove(client.feedClient)
+	}()
 
 	return nil
 }
 
-// Broadcast tickets to all WebSocket clients
-func (w *WebApp) broadcastTickets() {
-	w.wsClients.Lock()
-	defer w.wsClients.Unlock()
-	for c := range w.wsClients.clients {
-		err := c.WriteJSON(w.Tc.GetUnassignedTickets())
-		if err != nil {
-			c.Close()
-			delete(w.wsClients.clients, c)
-		}
+// waits for the client's hello and negotiates the protocol version. Replies with an error and returns
+// false if the hello is missing or malformed, or the client speaks no version this server does
+func handshake(conn *websocket.Conn) (int, bool) {
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return 0, false
 	}
-}
+	conn.SetReadDeadline(time.Time{})
 
-// send tickets to a single web socket client
-func (w *WebApp) sendTicketMessage(conn *websocket.Conn) bool {
-	err := conn.WriteJSON(w.Tc.GetUnassignedTickets())
+	e, err := protocol.Parse(data)
 	if err != nil {
-		conn.Close()
-		delete(w.wsClients.clients, conn)
-		return false
+		writeMessage(conn, protocol.Version, 0, protocol.Error{Message: err.Error()})
+		return 0, false
 	}
-	return true
-}
-
-// status messages
-
-// Status message struct for websocket broadcast
-type statusMessage struct {
-	Type         string `json:"type"`
-	LastApiCheck string `json:"lastApiCheck"`
-	IsActive     bool   `json:"isActive"`
+	hello, err := protocol.Decode[protocol.Hello](e)
+	if err != nil {
+		writeMessage(conn, protocol.Version, 0, protocol.Error{Message: err.Error()})
+		return 0, false
+	}
+	version, ok := protocol.Negotiate(hello.Versions)
+	if !ok {
+		writeMessage(conn, protocol.Version, 0, protocol.Error{
+			Message:           "no supported protocol version offered",
+			SupportedVersions: protocol.SupportedVersions,
+		})
+		return 0, false
+	}
+	return version, true
 }
 
-// Broadcast status to all WebSocket clients every 10 minutes
-func (w *WebApp) periodicallyBroadcastStatus() {
-	ticker := time.NewTicker(10 * time.Minute)
-	defer ticker.Stop()
-	for {
-		<-ticker.C
-		w.broadcastStatus()
+// encodes msg in a version v envelope and writes it to conn. Only used during the handshake
+func writeMessage[T protocol.ServerMessage](conn *websocket.Conn, v int, seq uint64, msg T) error {
+	data, err := protocol.Encode(v, seq, msg)
+	if err != nil {
+		return err
 	}
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return conn.WriteMessage(websocket.TextMessage, data)
 }
 
-// broadcasts status to a single websocket client
-func (w *WebApp) broadcastStatus() {
-	sm := statusMessage{
-		Type:         "status",
-		LastApiCheck: w.lastGoodApi.getTime().Format(time.RFC3339),
-		IsActive:     w.serverParams.getActive(),
+// client messages
+
+// runs a message received from a websocket client signed in as session. Messages of another protocol version,
+// unknown types and malformed messages are answered with an error
+func (w *WebApp) handleWsMessage(client *feedClient, session auth.Session, data []byte) {
+	e, err := protocol.Parse(data)
+	if err == nil && e.V != client.version {
+		err = errUnexpectedVersion
 	}
-	w.wsClients.Lock()
-	defer w.wsClients.Unlock()
-	for conn := range w.wsClients.clients {
-		w.sendStatusMessage(conn, sm)
+	if err != nil {
+		queueMessage(client, 0, protocol.Error{Message: err.Error()})
+		return
 	}
-}
-
-// sends status to a single websocket client
-func (w *WebApp) sendStatusMessage(conn *websocket.Conn, sm statusMessage) bool {
-	if err := conn.WriteJSON(sm); err != nil {
-		conn.Close()
-		delete(w.wsClients.clients, conn)
-		return false
+	remoteAddr := client.remoteAddr
+	switch e.Type {
+	case protocol.TypeResync:
+		w.resyncClient(client)
+	case protocol.TypeSubscribe:
+		sub, err := protocol.Decode[protocol.Subscribe](e)
+		if err == nil {
+			err = tickets.Filter(sub).Validate()
+		}
+		if err != nil {
+			queueMessage(client, 0, protocol.Error{Message: err.Error()})
+			return
+		}
+		w.subscribeClient(client, tickets.Filter(sub))
+	case protocol.TypeRefresh:
+		go func() {
+			queueCommandResult(client, e.Type, 0, w.refreshTickets())
+		}()
+	case protocol.TypePing:
+		w.sendPong(client)
+	case protocol.TypeClaim:
+		cmd, err := protocol.Decode[protocol.Claim](e)
+		if err != nil {
+			queueMessage(client, 0, protocol.Error{Message: err.Error()})
+			return
+		}
+		go func() {
+			_, err := w.claimTicket(newActionActor(session, cmd.ResourceID, remoteAddr), cmd.TicketID, cmd.RoleID)
+			queueCommandResult(client, e.Type, cmd.TicketID, err)
+		}()
+	case protocol.TypeNote:
+		cmd, err := protocol.Decode[protocol.Note](e)
+		if err != nil {
+			queueMessage(client, 0, protocol.Error{Message: err.Error()})
+			return
+		}
+		go func() {
+			err := w.addTicketNote(newActionActor(session, cmd.ResourceID, remoteAddr), cmd.TicketID, cmd.Title, cmd.Description)
+			queueCommandResult(client, e.Type, cmd.TicketID, err)
+		}()
+	case protocol.TypeSetStatus:
+		cmd, err := protocol.Decode[protocol.SetStatus](e)
+		if err != nil {
+			queueMessage(client, 0, protocol.Error{Message: err.Error()})
+			return
+		}
+		go func() {
+			_, err := w.setTicketStatus(newActionActor(session, cmd.ResourceID, remoteAddr), cmd.TicketID, cmd.Status)
+			queueCommandResult(client, e.Type, cmd.TicketID, err)
+		}()
+	default:
+		queueMessage(client, 0, protocol.Error{Message: errUnknownMessage.Error()})
 	}
-	return true
 }
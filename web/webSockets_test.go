@@ -0,0 +1,209 @@
+package web
+
+import (
+	"AutoTickets/auth"
+	"AutoTickets/protocol"
+	"AutoTickets/redact"
+	"AutoTickets/tickets"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// web app served by a test server, with no secrets or users loaded
+func newTestApp(t testing.TB) (*WebApp, *httptest.Server) {
+	t.Helper()
+	return newTestSsoApp(t, nil)
+}
+
+// web app served by a test server, with single sign-on through oidc if not nil
+func newTestSsoApp(t testing.TB, oidc *auth.OIDC) (*WebApp, *httptest.Server) {
+	t.Helper()
+	return newTestAppIn(t, t.TempDir(), oidc)
+}
+
+// web app keeping its files in dir, served by a test server
+func newTestAppIn(t testing.TB, dir string, oidc *auth.OIDC) (*WebApp, *httptest.Server) {
+	t.Helper()
+	users, err := auth.LoadUsers(dir + "/secrets.gob.users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWebApp(false, 30, 0, dir+"/secrets.gob", false, 0, 23, "", false, 600, dir+"/audit.log", redact.Default(), users, 12, oidc, "test")
+	srv := httptest.NewServer(w.E)
+	t.Cleanup(srv.Close)
+	return w, srv
+}
+
+// starts a session with role, returns its Cookie header
+func testSessionCookie(t testing.TB, w *WebApp, role auth.Role) string {
+	t.Helper()
+	return testUserCookie(t, w, auth.Session{Username: "tester", DisplayName: "tester", Role: role})
+}
+
+// starts session, returns its Cookie header
+func testUserCookie(t testing.TB, w *WebApp, session auth.Session) string {
+	t.Helper()
+	token, _, err := w.sessions.Create(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return (&http.Cookie{Name: sessionCookie, Value: token}).String()
+}
+
+// connects to /wsTickets with a standard session and completes the handshake, reading the welcome, snapshot and status
+func dialTestClient(t testing.TB, w *WebApp, srv *httptest.Server) *websocket.Conn {
+	t.Helper()
+	return dialTestSession(t, srv, testSessionCookie(t, w, auth.RoleStandard))
+}
+
+// connects to /wsTickets with the session in cookie and completes the handshake
+func dialTestSession(t testing.TB, srv *httptest.Server, cookie string) *websocket.Conn {
+	t.Helper()
+	header := http.Header{"Cookie": {cookie}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/wsTickets", header)
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"v":1,"type":"hello","seq":0,"data":{"versions":[1]}}`)); err != nil {
+		t.Fatalf("error sending hello: %v", err)
+	}
+	for _, want := range []string{protocol.TypeWelcome, protocol.TypeSnapshot, protocol.TypeStatus} {
+		if e := readEnvelope(t, conn); e.Type != want {
+			t.Fatalf("expected %s message, got %s", want, e.Type)
+		}
+	}
+	return conn
+}
+
+func readEnvelope(t testing.TB, conn *websocket.Conn) protocol.Envelope {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	e, err := protocol.Parse(data)
+	if err != nil {
+		t.Fatalf("error parsing %s: %v", data, err)
+	}
+	return e
+}
+
+// replaces the stored tickets with n unassigned tickets, titled with round so each round changes them all
+func setTestTickets(w *WebApp, n, round int) {
+	ts := make([]tickets.AutotaskTicket, n)
+	for i := range ts {
+		ts[i] = tickets.AutotaskTicket{ID: int64(i + 1), Title: fmt.Sprintf("ticket %d round %d", i+1, round)}
+	}
+	w.Tc.SetTickets(&ts)
+}
+
+func TestBroadcastTicketsDelta(t *testing.T) {
+	w, srv := newTestApp(t)
+	conn := dialTestClient(t, w, srv)
+
+	setTestTickets(w, 3, 1)
+	w.broadcastTickets()
+	e := readEnvelope(t, conn)
+	if e.Type != protocol.TypeDelta || e.Seq != 1 {
+		t.Fatalf("expected delta 1, got %s %d", e.Type, e.Seq)
+	}
+	var delta protocol.Delta
+	if err := json.Unmarshal(e.Data, &delta); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(delta.Added) != 3 {
+		t.Fatalf("expected 3 added tickets, got %+v", delta)
+	}
+
+	// no change, no message: the next message is the pong
+	w.broadcastTickets()
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"v":1,"type":"ping","seq":0}`))
+	if e := readEnvelope(t, conn); e.Type != protocol.TypePong || e.Seq != 1 {
+		t.Fatalf("expected pong at seq 1, got %s %d", e.Type, e.Seq)
+	}
+}
+
+func TestSlowConsumerEvicted(t *testing.T) {
+	w, _ := newTestApp(t)
+	// without a writer, the client never drains its queue
+	slow := newFeedClient(redact.RoleStandard, "", protocol.Version, "slow")
+	w.clients.clients[slow] = true
+
+	m, err := newOutMessage(protocol.Version, 0, "", protocol.Pong{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.clients.Lock()
+	for i := 0; i < sendQueueSize; i++ {
+		w.enqueue(slow, m)
+	}
+	if !w.clients.clients[slow] || slow.closed() {
+		w.clients.Unlock()
+		t.Fatal("client should not be evicted until its queue is full")
+	}
+	w.enqueue(slow, m)
+	w.clients.Unlock()
+
+	if w.clients.clients[slow] || !slow.closed() {
+		t.Fatal("client with a full queue should be evicted")
+	}
+	if slow.enqueue(m) {
+		t.Fatal("evicted clients should not accept messages")
+	}
+}
+
+// broadcasts a change to every ticket to clients simulated websocket clients, and waits until every client has read it
+func benchmarkBroadcastTickets(b *testing.B, clients int) {
+	w, srv := newTestApp(b)
+	conns := make([]*websocket.Conn, clients)
+	for i := range conns {
+		conns[i] = dialTestClient(b, w, srv)
+	}
+
+	var received sync.WaitGroup
+	for _, conn := range conns {
+		go func() {
+			for {
+				conn.SetReadDeadline(time.Time{})
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+				received.Done()
+			}
+		}()
+	}
+
+	// time spent in broadcastTickets itself, which only queues messages
+	var broadcasting time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		received.Add(clients)
+		setTestTickets(w, 50, i)
+		start := time.Now()
+		w.broadcastTickets()
+		broadcasting += time.Since(start)
+		received.Wait()
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(broadcasting.Nanoseconds())/float64(b.N), "broadcast-ns/op")
+
+	w.clients.Lock()
+	defer w.clients.Unlock()
+	if len(w.clients.clients) != clients {
+		b.Fatalf("expected %d clients, %d remain", clients, len(w.clients.clients))
+	}
+}
+
+func BenchmarkBroadcastTickets100(b *testing.B)  { benchmarkBroadcastTickets(b, 100) }
+func BenchmarkBroadcastTickets1000(b *testing.B) { benchmarkBroadcastTickets(b, 1000) }
+func BenchmarkBroadcastTickets5000(b *testing.B) { benchmarkBroadcastTickets(b, 5000) }
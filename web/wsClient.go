@@ -0,0 +1,62 @@
+package web
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// largest message accepted from a websocket client
+const maxMessageSize = 64 * 1024
+
+// a feed client connected over a websocket
+type wsClient struct {
+	*feedClient
+	conn *websocket.Conn
+}
+
+// writes queued messages to the connection and pings it, until the client is closed or a write fails
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.close()
+		c.conn.Close()
+	}()
+	for {
+		select {
+		case m := <-c.send:
+			prepared, err := m.websocketMessage()
+			if err != nil {
+				return
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WritePreparedMessage(prepared); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// reads messages from the connection and passes them to handle, until the connection fails,
+// or no pong arrives within pongWait
+func (c *wsClient) readPump(handle func(data []byte)) {
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		handle(data)
+	}
+}